@@ -0,0 +1,140 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ApkArchs maps the Go architectures to the Alpine architectures. Alpine
+// has no architecture for GOARM 5 builds.
+var ApkArchs = map[string]string{
+	"amd64":   "x86_64",
+	"386":     "x86",
+	"arm64":   "aarch64",
+	"arm":     "armv7",
+	"arm/v5":  "",
+	"arm/v6":  "armhf",
+	"ppc64le": "ppc64le",
+}
+
+// writeApk writes an Alpine package. It is two gzip streams joined together,
+// the control segment holding the .PKGINFO file and the data tarball with the
+// installed files. The control tarball has no end of archive marker, so the
+// package reads as a single tar. The package is not signed.
+func writeApk(w io.Writer, pkg Package, opts ArchiveOptions) error {
+	info, arch, err := getLinuxPackageInfo(pkg, opts, ApkArchs)
+	if err != nil {
+		return err
+	}
+
+	modTime := linuxPackageModTime(opts)
+	files, err := getLinuxPackageEntries(pkg, info, opts)
+	if err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+	if err := writeApkData(&data, addParentEntries(files, modTime)); err != nil {
+		return errors.Wrap(err, "creating data tarball")
+	}
+	dataHash := sha256.Sum256(data.Bytes())
+
+	var control bytes.Buffer
+	pkgInfo := apkPkgInfo(info, arch, installedSize(files), modTime.Unix(), dataHash[:])
+	if err := writeApkControl(&control, metadataEntry(".PKGINFO", pkgInfo, modTime)); err != nil {
+		return errors.Wrap(err, "creating control segment")
+	}
+
+	if _, err := w.Write(control.Bytes()); err != nil {
+		return errors.Wrap(err, "writing apk")
+	}
+	_, err = w.Write(data.Bytes())
+	return errors.Wrap(err, "writing apk")
+}
+
+// apkPkgInfo generates the .PKGINFO file of the package
+func apkPkgInfo(info LinuxPackageInfo, arch string, size int64, buildDate int64,
+	dataHash []byte) []byte {
+	var pkgInfo bytes.Buffer
+	fmt.Fprintf(&pkgInfo, "# Generated by gop\n")
+	fmt.Fprintf(&pkgInfo, "pkgname = %s\n", info.Name)
+	fmt.Fprintf(&pkgInfo, "pkgver = %s-r%s\n", info.Version, info.Release)
+	fmt.Fprintf(&pkgInfo, "pkgdesc = %s\n", info.Summary)
+	if info.Homepage != "" {
+		fmt.Fprintf(&pkgInfo, "url = %s\n", info.Homepage)
+	}
+	fmt.Fprintf(&pkgInfo, "builddate = %d\n", buildDate)
+	if info.Maintainer != "" {
+		fmt.Fprintf(&pkgInfo, "packager = %s\n", info.Maintainer)
+		fmt.Fprintf(&pkgInfo, "maintainer = %s\n", info.Maintainer)
+	}
+	fmt.Fprintf(&pkgInfo, "size = %d\n", size)
+	fmt.Fprintf(&pkgInfo, "arch = %s\n", arch)
+	fmt.Fprintf(&pkgInfo, "origin = %s\n", info.Name)
+	if info.License != "" {
+		fmt.Fprintf(&pkgInfo, "license = %s\n", info.License)
+	}
+	for _, dep := range info.Depends {
+		// apk writes versioned dependencies without spaces, e.g. "musl>=1.1"
+		fmt.Fprintf(&pkgInfo, "depend = %s\n", strings.Join(strings.Fields(dep), ""))
+	}
+	fmt.Fprintf(&pkgInfo, "datahash = %x\n", dataHash)
+	return pkgInfo.Bytes()
+}
+
+// writeApkControl writes the gzipped control segment, with the end of
+// archive marker cut off the tarball
+func writeApkControl(w io.Writer, entry archiveEntry) error {
+	var control bytes.Buffer
+	if err := writeLinuxTar(&control, []archiveEntry{entry}, ""); err != nil {
+		return err
+	}
+	// the marker is two empty blocks
+	control.Truncate(control.Len() - 1024)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(control.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeApkData writes the gzipped data tarball. Each file is given the SHA1
+// checksum of its contents, which apk checks when it is installed.
+func writeApkData(w io.Writer, entries []archiveEntry) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		hdr, err := linuxTarHeader(entry, "")
+		if err != nil {
+			return err
+		}
+		if entry.info.Mode().IsRegular() {
+			data, err := readArchiveEntry(entry)
+			if err != nil {
+				return err
+			}
+			hdr.Format = tar.FormatPAX
+			hdr.PAXRecords = map[string]string{
+				"APK-TOOLS.checksum.SHA1": fmt.Sprintf("%x", sha1.Sum(data)),
+			}
+			// write the contents that were hashed rather than reading the file again
+			entry = metadataEntry(entry.name, data, entry.info.ModTime())
+		}
+		if err := writeLinuxTarEntry(tw, hdr, entry); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "closing tar")
+	}
+	return errors.Wrap(gz.Close(), "closing gzip")
+}
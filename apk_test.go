@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApkPkgInfo(t *testing.T) {
+	info := LinuxPackageInfo{
+		Name:    "gop",
+		Version: "1.0.0",
+		Release: "2",
+		Summary: "Go packager",
+		License: "MIT",
+		Depends: []string{"musl >= 1.1", "tar"},
+	}
+	expected := "# Generated by gop\n" +
+		"pkgname = gop\n" +
+		"pkgver = 1.0.0-r2\n" +
+		"pkgdesc = Go packager\n" +
+		"builddate = 315532800\n" +
+		"size = 1024\n" +
+		"arch = armv7\n" +
+		"origin = gop\n" +
+		"license = MIT\n" +
+		"depend = musl>=1.1\n" +
+		"depend = tar\n" +
+		"datahash = 0102\n"
+	pkgInfo := apkPkgInfo(info, ApkArchs["arm"], 1024, ReproducibleModTime.Unix(), []byte{1, 2})
+	assert.Equal(t, expected, string(pkgInfo), "pkginfo does not match")
+}
+
+func TestWriteApk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "exe")
+	readmePath := filepath.Join(dir, "README.md")
+	assert.NoError(t, ioutil.WriteFile(exePath, []byte("binary"), 0700))
+	assert.NoError(t, ioutil.WriteFile(readmePath, []byte("readme"), 0600))
+
+	pkg := Package{OS: "linux", Arch: "amd64", Dir: "exe", Archive: "apk", ExePath: exePath,
+		FileList: []string{exePath, readmePath}}
+	opts := ArchiveOptions{Reproducible: true, ModTime: ReproducibleModTime,
+		PackageInfo: map[string]LinuxPackageInfo{"apk": {Version: "1.0.0"}}}
+	var out bytes.Buffer
+	assert.NoError(t, writeApk(&out, pkg, opts), "unexpected error")
+	apk := out.Bytes()
+
+	// the control segment is the first of two gzip streams
+	r := bytes.NewReader(apk)
+	br := bufio.NewReader(r)
+	gz, err := gzip.NewReader(br)
+	assert.NoError(t, err, "unexpected error")
+	gz.Multistream(false)
+	control, err := ioutil.ReadAll(gz)
+	assert.NoError(t, err, "unexpected error")
+	dataStart := len(apk) - r.Len() - br.Buffered()
+
+	// the end of archive marker is cut off, leaving the last block of .PKGINFO
+	assert.Equal(t, 0, len(control)%512, "control is not made of tar blocks")
+	assert.NotEqual(t, make([]byte, 512), control[len(control)-512:],
+		"control ends with an empty block")
+	entries := readTestTar(t, bytes.NewReader(control), false)
+	assert.Equal(t, []string{".PKGINFO"}, testTarNames(entries), "control entries do not match")
+	dataHash := sha256.Sum256(apk[dataStart:])
+	assert.Contains(t, entries[0].data, "pkgname = exe\npkgver = 1.0.0-r1\n", "pkginfo does not match")
+	assert.Contains(t, entries[0].data, "arch = x86_64\n", "pkginfo does not match")
+	assert.Contains(t, entries[0].data, fmt.Sprintf("datahash = %x\n", dataHash),
+		"pkginfo data hash does not match")
+
+	expected := []testTarEntry{
+		{"usr/", 0755, ""},
+		{"usr/bin/", 0755, ""},
+		{"usr/bin/exe", 0755, "binary"},
+		{"usr/share/", 0755, ""},
+		{"usr/share/doc/", 0755, ""},
+		{"usr/share/doc/exe/", 0755, ""},
+		{"usr/share/doc/exe/README.md", 0644, "readme"},
+	}
+	assert.Equal(t, expected, readTestTar(t, bytes.NewReader(apk[dataStart:]), true),
+		"data entries do not match")
+
+	// each file carries the checksum apk checks on install
+	gz, err = gzip.NewReader(bytes.NewReader(apk[dataStart:]))
+	assert.NoError(t, err, "unexpected error")
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF || !assert.NoError(t, err, "unexpected error") {
+			break
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		assert.NoError(t, err, "unexpected error")
+		assert.Equal(t, fmt.Sprintf("%x", sha1.Sum(data)), hdr.PAXRecords["APK-TOOLS.checksum.SHA1"],
+			"%s checksum does not match", hdr.Name)
+	}
+}
+
+func TestApkArchs(t *testing.T) {
+	opts := ArchiveOptions{PackageInfo: map[string]LinuxPackageInfo{"apk": {Version: "1.0.0"}}}
+	tests := map[string]string{"": "armv7", "v6": "armhf", "v7": "armv7"}
+	for variant, expected := range tests {
+		pkg := Package{OS: "linux", Arch: "arm", Variant: variant, Archive: "apk"}
+		_, arch, err := getLinuxPackageInfo(pkg, opts, ApkArchs)
+		assert.NoError(t, err, "unexpected error")
+		assert.Equal(t, expected, arch, "arm %s arch does not match", variant)
+		assert.True(t, IsSupportedPackage(pkg), "arm %s apk is not supported", variant)
+	}
+
+	pkg := Package{OS: "linux", Arch: "arm", Variant: "v5", Archive: "apk"}
+	_, _, err := getLinuxPackageInfo(pkg, opts, ApkArchs)
+	assert.EqualError(t, err, "apk packages do not support the arm/v5 architecture")
+	assert.False(t, IsSupportedPackage(pkg), "arm v5 apk is supported")
+}
@@ -1,51 +1,332 @@
 package main
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/mholt/archiver"
 	"github.com/pkg/errors"
 )
 
-func archive(archivePath string, archiveType string, files []string) error {
+const (
+	// ExeFileMode is the default mode of the executable inside an archive
+	ExeFileMode os.FileMode = 0755
+	// DirFileMode is the default mode of directories inside an archive
+	DirFileMode os.FileMode = 0755
+	// ExtraFileMode is the default mode of the additional files inside an archive
+	ExtraFileMode os.FileMode = 0644
+)
+
+// ReproducibleModTime is the timestamp given to archive entries in
+// reproducible mode when SOURCE_DATE_EPOCH is not set. It is the earliest
+// time that can be stored in a zip file.
+var ReproducibleModTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ArchiveOptions controls how the entries of an archive are written
+type ArchiveOptions struct {
+	// Modes overrides the default mode of any matching files
+	Modes []FileMode
+	// Reproducible sorts the entries, gives them all the same timestamp and
+	// strips any ownership info so identical inputs create identical archives
+	Reproducible bool
+	// ModTime is the timestamp of every entry when Reproducible is set
+	ModTime time.Time
+	// CompressionLevels is the compression level of each archive format.
+	// Formats that are missing use their default level.
+	CompressionLevels map[string]int
+	// ZstdLevel is the compression level of tar.zst archives
+	ZstdLevel int
+	// PackageInfo is the metadata of each linux package format
+	PackageInfo map[string]LinuxPackageInfo
+}
+
+// archiveEntry is a single file or directory to be written to an archive.
+// The contents come from the file at path, or from data if there is no path.
+type archiveEntry struct {
+	name string
+	path string
+	data []byte
+	info os.FileInfo
+}
+
+// archive writes the package files to a new archive at the package archive
+// path. Any sinks are given a copy of the archive as it is written.
+func archive(pkg Package, opts ArchiveOptions, sinks ...io.Writer) (err error) {
+	archivePath, archiveType := pkg.ArchivePath, pkg.Archive
+	format, isLinuxPackage := LinuxPackageFormats[CanonicalArchive(archiveType)]
+
+	var writer archiver.Writer
+	entries := []archiveEntry{}
+	if !isLinuxPackage {
+		if writer, err = newArchiveWriter(archiveType, opts); err != nil {
+			return err
+		}
+		if entries, err = getArchiveEntries(pkg, opts); err != nil {
+			return errors.Wrapf(err, "archiving %s", archiveType)
+		}
+	}
+
+	if _, err := os.Stat(archivePath); err == nil {
+		return errors.Errorf("file already exists: %s", archivePath)
+	}
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		return errors.Wrap(err, "making archive folder")
+	}
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return errors.Wrapf(err, "creating %s", archivePath)
+	}
+	// don't leave a partial archive behind to block the next run
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(archivePath)
+		}
+	}()
+
+	// anything in sinks (checksums, etc) sees the same bytes that hit the disk
+	w := io.MultiWriter(append([]io.Writer{out}, sinks...)...)
+	if isLinuxPackage {
+		if err := format.Write(w, pkg, opts); err != nil {
+			return errors.Wrapf(err, "packaging %s", archiveType)
+		}
+		return out.Close()
+	}
+
+	if err := writer.Create(w); err != nil {
+		return errors.Wrapf(err, "archiving %s", archiveType)
+	}
+	for _, entry := range entries {
+		if err := writeArchiveEntry(writer, entry); err != nil {
+			writer.Close()
+			return errors.Wrapf(err, "archiving %s", archiveType)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return errors.Wrapf(err, "archiving %s", archiveType)
+	}
+	return out.Close()
+}
+
+// getArchiveEntries creates the entries of an archive holding the package
+// files under the archive root
+func getArchiveEntries(pkg Package, opts ArchiveOptions) ([]archiveEntry, error) {
+	entries := getArchiveRootEntries(pkg.ArchiveRoot)
+	for _, file := range pkg.FileList {
+		name := path.Join(pkg.ArchiveRoot, pkg.FileName(file))
+		mode := ExtraFileMode
+		if file == pkg.ExePath {
+			mode = ExeFileMode
+			if pkg.BinaryName != "" {
+				name = path.Join(pkg.ArchiveRoot, pkg.BinaryName)
+			}
+		}
+		fileEntries, err := getArchiveFileEntries(file, name, mode, opts.Modes, pkg.Excludes)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fileEntries...)
+	}
+
+	if opts.Reproducible {
+		entries = makeReproducible(entries, opts.ModTime)
+	}
+	return entries, nil
+}
+
+// makeReproducible sorts the entries by name and gives them all the same
+// timestamp
+func makeReproducible(entries []archiveEntry, modTime time.Time) []archiveEntry {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	for i := range entries {
+		entries[i].info = fixedInfo{FileInfo: entries[i].info, modTime: modTime}
+	}
+	return entries
+}
+
+func newArchiveWriter(archiveType string, opts ArchiveOptions) (archiver.Writer, error) {
+	level, hasLevel := opts.CompressionLevels[CanonicalArchive(archiveType)]
 	switch strings.ToLower(archiveType) {
 	case "zip":
+		if hasLevel && level == noCompression {
+			return NewStoredZip(), nil
+		}
 		zip := archiver.NewZip()
-		if err := zip.Archive(files, archivePath); err != nil {
-			return errors.Wrap(err, "archving zip")
+		if hasLevel {
+			zip.CompressionLevel = level
 		}
+		return zip, nil
 	case "tar":
-		tar := archiver.NewTar()
-		if err := tar.Archive(files, archivePath); err != nil {
-			return errors.Wrap(err, "archving tar")
-		}
+		return archiver.NewTar(), nil
 	case "tbz2", "tar.bz2":
 		tarbz2 := archiver.NewTarBz2()
-		if err := tarbz2.Archive(files, archivePath); err != nil {
-			return errors.Wrap(err, "archving tar.bz2")
+		if hasLevel {
+			tarbz2.CompressionLevel = level
 		}
+		return tarbz2, nil
 	case "tgz", "tar.gz":
 		targz := archiver.NewTarGz()
-		if err := targz.Archive(files, archivePath); err != nil {
-			return errors.Wrap(err, "archving tar.gz")
+		if hasLevel {
+			targz.CompressionLevel = level
 		}
+		return targz, nil
 	case "tlz4", "tar.lz4":
 		tarlz4 := archiver.NewTarLz4()
-		if err := tarlz4.Archive(files, archivePath); err != nil {
-			return errors.Wrap(err, "archving tar.lz4")
+		if hasLevel {
+			tarlz4.CompressionLevel = level
 		}
+		return tarlz4, nil
 	case "tsz", "tar.sz":
-		tarsz := archiver.NewTarSz()
-		if err := tarsz.Archive(files, archivePath); err != nil {
-			return errors.Wrap(err, "archving tar.sz")
-		}
+		return archiver.NewTarSz(), nil
 	case "txz", "tar.xz":
-		tarxz := archiver.NewTarXz()
-		if err := tarxz.Archive(files, archivePath); err != nil {
-			return errors.Wrap(err, "archving tar.xz")
+		if hasLevel {
+			return NewTarXz(level), nil
 		}
+		return archiver.NewTarXz(), nil
+	case "tzst", "tar.zst":
+		return NewTarZst(opts.ZstdLevel), nil
 	default:
-		return errors.Errorf("unknown archving format '%s'", archiveType)
+		return nil, errors.Errorf("unknown archving format '%s'", archiveType)
+	}
+}
+
+// getArchiveRootEntries creates a directory entry for each level of the
+// archive root
+func getArchiveRootEntries(root string) []archiveEntry {
+	entries := []archiveEntry{}
+	name := ""
+	for _, part := range strings.Split(root, "/") {
+		if part == "" {
+			continue
+		}
+		name = path.Join(name, part)
+		entries = append(entries, archiveEntry{
+			name: name,
+			info: memInfo{name: part, mode: os.ModeDir | DirFileMode, modTime: time.Now()},
+		})
+	}
+	return entries
+}
+
+// getArchiveFileEntries creates the entries needed to add the source to the
+// archive with the given name. Directories are added recursively, with their
+// contents under the name, leaving out anything that matches an exclude
+// pattern. Files are given the file mode unless a mode override matches them.
+func getArchiveFileEntries(source string, name string, fileMode os.FileMode,
+	modes []FileMode, excludes []string) ([]archiveEntry, error) {
+	entries := []archiveEntry{}
+	err := filepath.Walk(source, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "traversing %s", fpath)
+		}
+
+		rel, err := filepath.Rel(source, fpath)
+		if err != nil {
+			return errors.Wrapf(err, "naming %s", fpath)
+		}
+		if rel != "." && IsExcludedFile(excludes, fpath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		mode := fileMode
+		if info.IsDir() {
+			mode = DirFileMode
+		}
+		mode = MatchFileMode(modes, fpath, mode)
+
+		entries = append(entries, archiveEntry{
+			name: path.Join(name, filepath.ToSlash(rel)),
+			path: fpath,
+			info: modeInfo{FileInfo: info, perm: mode},
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// writeArchiveEntry writes the entry, along with the contents of any regular
+// file, to the archive
+func writeArchiveEntry(writer archiver.Writer, entry archiveEntry) error {
+	file, err := openArchiveEntry(entry)
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		defer file.Close()
 	}
-	return nil
+
+	err = writer.Write(archiver.File{
+		FileInfo: archiver.FileInfo{
+			FileInfo:   entry.info,
+			CustomName: entry.name,
+		},
+		ReadCloser: file,
+	})
+	return errors.Wrapf(err, "writing %s", entry.name)
+}
+
+// openArchiveEntry opens the contents of a regular file entry. Nil is
+// returned for any other entry.
+func openArchiveEntry(entry archiveEntry) (io.ReadCloser, error) {
+	if !entry.info.Mode().IsRegular() {
+		return nil, nil
+	}
+	if entry.path == "" {
+		return ioutil.NopCloser(bytes.NewReader(entry.data)), nil
+	}
+	file, err := os.Open(entry.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", entry.path)
+	}
+	return file, nil
+}
+
+// memInfo describes a file or directory that only exists inside of an archive
+type memInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
 }
+
+func (m memInfo) Name() string       { return m.name }
+func (m memInfo) Size() int64        { return m.size }
+func (m memInfo) Mode() os.FileMode  { return m.mode }
+func (m memInfo) ModTime() time.Time { return m.modTime }
+func (m memInfo) IsDir() bool        { return m.mode.IsDir() }
+func (m memInfo) Sys() interface{}   { return nil }
+
+// modeInfo replaces the permissions of a file, leaving the file type intact
+type modeInfo struct {
+	os.FileInfo
+	perm os.FileMode
+}
+
+func (m modeInfo) Mode() os.FileMode {
+	return m.FileInfo.Mode()&^(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky) | m.perm
+}
+
+// fixedInfo gives a file a fixed timestamp and hides the underlying system
+// info, so the archive writers leave the uid, gid, user and group empty.
+// The compressors used by the archive writers do not store a timestamp or
+// file name of their own, so this is all that is needed for the output to
+// be reproducible.
+type fixedInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (f fixedInfo) ModTime() time.Time { return f.modTime }
+func (f fixedInfo) Sys() interface{}   { return nil }
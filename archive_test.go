@@ -0,0 +1,273 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchive_Reproducible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "exe")
+	readmePath := filepath.Join(dir, "README.md")
+	assert.NoError(t, ioutil.WriteFile(exePath, []byte("binary"), 0700))
+	assert.NoError(t, ioutil.WriteFile(readmePath, []byte("readme"), 0600))
+
+	opts := ArchiveOptions{Reproducible: true, ModTime: ReproducibleModTime,
+		PackageInfo: map[string]LinuxPackageInfo{}}
+	for format := range LinuxPackageFormats {
+		opts.PackageInfo[format] = LinuxPackageInfo{Version: "1.0.0", Maintainer: "gop"}
+	}
+	for _, archiveType := range ArchiveList {
+		pkg := Package{
+			OS:          "linux",
+			Arch:        "amd64",
+			Dir:         "exe",
+			Archive:     archiveType,
+			ExePath:     exePath,
+			ArchiveRoot: "exe-linux-amd64",
+			BinaryName:  "exe",
+			FileList:    []string{readmePath, exePath},
+		}
+
+		pkg.ArchivePath = filepath.Join(dir, "first."+archiveType)
+		assert.NoError(t, archive(pkg, opts), "unexpected error")
+
+		later := time.Now().Add(time.Hour)
+		assert.NoError(t, os.Chtimes(exePath, later, later))
+		assert.NoError(t, os.Chmod(readmePath, 0666))
+
+		pkg.FileList = []string{exePath, readmePath}
+		pkg.ArchivePath = filepath.Join(dir, "second."+archiveType)
+		assert.NoError(t, archive(pkg, opts), "unexpected error")
+
+		first, _ := ioutil.ReadFile(filepath.Join(dir, "first."+archiveType))
+		second, _ := ioutil.ReadFile(filepath.Join(dir, "second."+archiveType))
+		assert.NotEmpty(t, first, "archive is empty")
+		assert.Equal(t, first, second, "%s archives are not identical", archiveType)
+	}
+}
+
+func TestArchive_Exists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "exe")
+	assert.NoError(t, ioutil.WriteFile(exePath, []byte("binary"), 0755))
+
+	pkg := Package{Archive: "zip", ExePath: exePath, FileList: []string{exePath},
+		ArchivePath: filepath.Join(dir, "exe.zip")}
+	assert.NoError(t, archive(pkg, ArchiveOptions{}), "unexpected error")
+	assert.Error(t, archive(pkg, ArchiveOptions{}), "expected error")
+}
+
+func TestArchive_Root(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "exe_linux_amd64")
+	readmePath := filepath.Join(dir, "README.md")
+	assert.NoError(t, ioutil.WriteFile(exePath, []byte("binary"), 0755))
+	assert.NoError(t, ioutil.WriteFile(readmePath, []byte("readme"), 0644))
+
+	for _, archiveType := range []string{"zip", "tar.gz"} {
+		pkgs, err := GetPackageRoots([]Package{Package{OS: "linux", Arch: "amd64", Dir: "exe",
+			Archive: archiveType, ExePath: exePath, FileList: []string{exePath, readmePath},
+			ArchivePath: filepath.Join(dir, "exe."+archiveType)}}, "{{.Dir}}-v1/{{.OS}}-{{.Arch}}")
+		assert.NoError(t, err, "unexpected error")
+		assert.NoError(t, archive(pkgs[0], ArchiveOptions{}), "unexpected error")
+
+		expected := []string{"exe-v1/", "exe-v1/linux-amd64/", "exe-v1/linux-amd64/exe_linux_amd64",
+			"exe-v1/linux-amd64/README.md"}
+		assert.ElementsMatch(t, expected, testArchiveNames(readTestArchive(t, pkgs[0].ArchivePath)),
+			"%s entries do not match", archiveType)
+	}
+}
+
+func TestArchive_BinaryName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	readmePath := filepath.Join(dir, "README.md")
+	assert.NoError(t, ioutil.WriteFile(readmePath, []byte("readme"), 0644))
+
+	tests := []struct {
+		os, archive, binaryName string
+	}{
+		{"linux", "tar.gz", "myapp"},
+		{"linux", "zip", "myapp"},
+		{"windows", "zip", "myapp.exe"},
+	}
+	for _, test := range tests {
+		exePath := filepath.Join(dir, "myapp_"+test.os+"_amd64")
+		assert.NoError(t, ioutil.WriteFile(exePath, []byte("binary"), 0755))
+
+		pkgs, err := GetPackageBinaryNames([]Package{Package{OS: test.os, Arch: "amd64",
+			Dir: "myapp", Archive: test.archive, ExePath: exePath,
+			FileList:    []string{exePath, readmePath},
+			ArchivePath: filepath.Join(dir, "myapp_"+test.os+"."+test.archive)}}, "{{.Dir}}")
+		assert.NoError(t, err, "unexpected error")
+		assert.NoError(t, archive(pkgs[0], ArchiveOptions{}), "unexpected error")
+
+		assert.ElementsMatch(t, []string{test.binaryName, "README.md"},
+			testArchiveNames(readTestArchive(t, pkgs[0].ArchivePath)),
+			"%s %s entries do not match", test.os, test.archive)
+	}
+}
+
+func TestArchive_Modes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "exe")
+	readmePath := filepath.Join(dir, "README.md")
+	installPath := filepath.Join(dir, "install.sh")
+	for _, filePath := range []string{exePath, readmePath, installPath} {
+		assert.NoError(t, ioutil.WriteFile(filePath, []byte("data"), 0600))
+		assert.NoError(t, os.Chmod(filePath, 0600))
+	}
+
+	modes, err := GetUserFileModes([]string{"install.sh=0750"})
+	assert.NoError(t, err, "unexpected error")
+	for _, archiveType := range []string{"zip", "tar.gz"} {
+		pkg := Package{Archive: archiveType, ExePath: exePath,
+			FileList:    []string{exePath, readmePath, installPath},
+			ArchivePath: filepath.Join(dir, "exe."+archiveType)}
+		assert.NoError(t, archive(pkg, ArchiveOptions{Modes: modes}), "unexpected error")
+
+		expected := map[string]os.FileMode{"exe": 0755, "README.md": 0644, "install.sh": 0750}
+		assert.Equal(t, expected, readTestArchive(t, pkg.ArchivePath),
+			"%s modes do not match", archiveType)
+	}
+}
+
+func TestArchive_ZipStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "exe")
+	assert.NoError(t, ioutil.WriteFile(exePath, []byte("binary binary binary"), 0755))
+
+	tests := []struct {
+		levels map[string]int
+		method uint16
+	}{
+		{map[string]int{"zip": 0}, zip.Store},
+		{map[string]int{"zip": 9}, zip.Deflate},
+		{map[string]int{}, zip.Deflate},
+	}
+	for i, test := range tests {
+		pkg := Package{Archive: "zip", ExePath: exePath, ArchiveRoot: "exe",
+			FileList: []string{exePath}, ArchivePath: filepath.Join(dir, fmt.Sprintf("exe%d.zip", i))}
+		assert.NoError(t, archive(pkg, ArchiveOptions{CompressionLevels: test.levels}),
+			"unexpected error")
+
+		zr, err := zip.OpenReader(pkg.ArchivePath)
+		assert.NoError(t, err, "unexpected error")
+		assert.Len(t, zr.File, 2, "incorrect number of files")
+		for _, file := range zr.File {
+			if file.Mode().IsDir() {
+				continue
+			}
+			assert.Equal(t, "exe/exe", file.Name, "file name does not match")
+			assert.Equal(t, test.method, file.Method, "compression method does not match")
+			assert.Equal(t, os.FileMode(0755), file.Mode(), "file mode does not match")
+
+			rc, err := file.Open()
+			assert.NoError(t, err, "unexpected error")
+			data, err := ioutil.ReadAll(rc)
+			rc.Close()
+			assert.NoError(t, err, "unexpected error")
+			assert.Equal(t, "binary binary binary", string(data), "file contents do not match")
+		}
+		zr.Close()
+	}
+}
+
+func TestArchive_GlobFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "exe")
+	assert.NoError(t, ioutil.WriteFile(exePath, []byte("binary"), 0755))
+	for _, file := range []string{"docs/README.md", "docs/api/README.md"} {
+		filePath := filepath.Join(dir, file)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0755))
+		assert.NoError(t, ioutil.WriteFile(filePath, []byte(file), 0644))
+	}
+
+	pkg, err := NewFileResolver().Resolve(Package{OS: "linux", Arch: "amd64", Dir: "exe",
+		Archive: "zip", ExePath: exePath, ArchiveRoot: "exe",
+		FileList:    []string{exePath, filepath.Join(dir, "docs/**/*.md")},
+		ArchivePath: filepath.Join(dir, "exe.zip")})
+	assert.NoError(t, err, "unexpected error")
+	assert.NoError(t, archive(pkg, ArchiveOptions{}), "unexpected error")
+	assert.ElementsMatch(t, []string{"exe/", "exe/exe", "exe/README.md", "exe/api/README.md"},
+		testArchiveNames(readTestArchive(t, pkg.ArchivePath)), "entries do not match")
+
+	info := LinuxPackageInfo{Name: "exe", InstallPath: DefaultInstallPath, DocPath: DefaultDocPath}
+	entries, err := getLinuxPackageEntries(pkg, info, ArchiveOptions{})
+	assert.NoError(t, err, "unexpected error")
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.name)
+	}
+	assert.Equal(t, []string{"usr/bin/exe", "usr/share/doc/exe/README.md",
+		"usr/share/doc/exe/api/README.md"}, names, "package entries do not match")
+}
+
+// readTestArchive reads the mode of each entry in a zip or tar.gz archive
+func readTestArchive(t *testing.T, archivePath string) map[string]os.FileMode {
+	modes := map[string]os.FileMode{}
+	if filepath.Ext(archivePath) == ".zip" {
+		zr, err := zip.OpenReader(archivePath)
+		assert.NoError(t, err, "unexpected error")
+		defer zr.Close()
+		for _, file := range zr.File {
+			modes[file.Name] = file.Mode()
+		}
+		return modes
+	}
+
+	file, err := os.Open(archivePath)
+	assert.NoError(t, err, "unexpected error")
+	defer file.Close()
+	gr, err := gzip.NewReader(file)
+	assert.NoError(t, err, "unexpected error")
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF || !assert.NoError(t, err, "unexpected error") {
+			break
+		}
+		modes[header.Name] = header.FileInfo().Mode()
+	}
+	return modes
+}
+
+// testArchiveNames returns the names of the entries read by readTestArchive
+func testArchiveNames(modes map[string]os.FileMode) []string {
+	names := []string{}
+	for name := range modes {
+		names = append(names, name)
+	}
+	return names
+}
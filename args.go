@@ -3,12 +3,13 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"html/template"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/template"
 
 	"github.com/gesquive/cli"
 	"github.com/pkg/errors"
@@ -16,54 +17,153 @@ import (
 
 // Package is a combination of OS/arch/archive that can be packaged.
 type Package struct {
-	OS          string
-	Arch        string
-	Archive     string
-	ExePath     string
-	ArchivePath string
-	FileList    []string
-	Dir         string
+	OS          string   `json:"os"`
+	Arch        string   `json:"arch"`
+	Variant     string   `json:"variant,omitempty"`
+	Archive     string   `json:"archive"`
+	ExePath     string   `json:"exe_path"`
+	ArchivePath string   `json:"archive_path"`
+	FileList    []string `json:"files"`
+	Dir         string   `json:"dir"`
+	Checksum    string   `json:"checksum,omitempty"`
+	ArchiveRoot string   `json:"archive_root,omitempty"`
+	BinaryName  string   `json:"binary_name,omitempty"`
+	Excludes    []string `json:"excludes,omitempty"`
+	// FileNames maps the additional files to their paths inside the archive
+	FileNames map[string]string `json:"-"`
+	BuildInfo
 }
 
 func (p *Package) String() string {
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s/%s", p.OS, p.Arch, p.Variant, p.Archive)
+	}
 	return fmt.Sprintf("%s/%s/%s", p.OS, p.Arch, p.Archive)
 }
 
+// FileName is the path of an additional file inside the archive, relative to
+// the archive root. Files without a resolved name keep their base name.
+func (p Package) FileName(file string) string {
+	if name, ok := p.FileNames[file]; ok {
+		return name
+	}
+	return filepath.Base(file)
+}
+
+// Ext is the file extension of the archive. It is the archive name, except
+// for formats whose files end differently, such as pacman packages.
+func (p Package) Ext() string {
+	if ext, ok := ArchiveExtensions[CanonicalArchive(p.Archive)]; ok {
+		return ext
+	}
+	return p.Archive
+}
+
+// Platform is the arch of the package along with its variant, if any
+func (p *Package) Platform() string {
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s", p.Arch, p.Variant)
+	}
+	return p.Arch
+}
+
+// FileMode is the mode given to the archived files matching the pattern
+type FileMode struct {
+	Pattern string
+	Mode    os.FileMode
+}
+
+// ParseFileMode parses a "pattern=mode" string where mode is in octal
+func ParseFileMode(modeString string) (FileMode, error) {
+	fileMode := FileMode{}
+	parts := strings.SplitN(modeString, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fileMode, errors.Errorf("could not parse file mode '%s'", modeString)
+	}
+	mode, err := strconv.ParseUint(parts[1], 8, 32)
+	if err != nil || os.FileMode(mode)&^os.ModePerm != 0 {
+		return fileMode, errors.Errorf("invalid file mode in '%s'", modeString)
+	}
+	fileMode.Pattern = filepath.Clean(parts[0])
+	fileMode.Mode = os.FileMode(mode)
+	return fileMode, nil
+}
+
+// ParsePackage parses an "os/arch/archive" or "os/arch/variant/archive"
+// string. A variant given as a bare GOARM or GOAMD64 number, such as "7", is
+// stored as "v7".
 func ParsePackage(pkgString string) (Package, error) {
 	pkg := Package{}
-	parts := strings.SplitN(pkgString, "/", 3)
-	if len(parts) != 3 {
+	parts := strings.Split(pkgString, "/")
+	switch len(parts) {
+	case 3:
+		pkg.OS, pkg.Arch, pkg.Archive = parts[0], parts[1], parts[2]
+	case 4:
+		pkg.OS, pkg.Arch, pkg.Variant, pkg.Archive = parts[0], parts[1], parts[2], parts[3]
+	default:
 		return pkg, errors.Errorf("could not parse package '%s'", pkgString)
 	}
-	pkg.OS = parts[0]
-	pkg.Arch = parts[1]
-	pkg.Archive = parts[2]
+
+	if pkg.Variant != "" {
+		if _, err := strconv.Atoi(pkg.Variant); err == nil {
+			pkg.Variant = "v" + pkg.Variant
+		}
+		pkg.Variant = strings.ToLower(pkg.Variant)
+		if !containsItem(ArchVariants[strings.ToLower(pkg.Arch)], pkg.Variant) {
+			return pkg, errors.Errorf("unknown %s variant '%s' in package '%s'",
+				pkg.Arch, pkg.Variant, pkgString)
+		}
+	}
 	return pkg, nil
 }
 
 var (
-	// OSList is the full list of golang OSs
+	// OSList is the full list of golang OSs, replaced by the list from the go
+	// tool when it is available
 	OSList = []string{
+		"aix",
+		"android",
 		"darwin",
 		"dragonfly",
 		"freebsd",
+		"illumos",
+		"ios",
+		"js",
 		"linux",
 		"netbsd",
 		"openbsd",
 		"plan9",
 		"solaris",
+		"wasip1",
 		"windows",
 	}
 
-	// ArchList is the full list of golang architectures
+	// ArchList is the full list of golang architectures, replaced by the list
+	// from the go tool when it is available
 	ArchList = []string{
 		"386",
 		"amd64",
-		"amd64p32",
 		"arm",
 		"arm64",
+		"loong64",
+		"mips",
+		"mips64",
+		"mips64le",
+		"mipsle",
 		"ppc64",
 		"ppc64le",
+		"riscv64",
+		"s390x",
+		"wasm",
+	}
+
+	// ArchVariants are the sub-architecture variants of each architecture,
+	// set with GOARM, GOAMD64 and GOMIPS when building
+	ArchVariants = map[string][]string{
+		"amd64":  {"v1", "v2", "v3", "v4"},
+		"arm":    {"v5", "v6", "v7"},
+		"mips":   {"hardfloat", "softfloat"},
+		"mipsle": {"hardfloat", "softfloat"},
 	}
 
 	// DefaultArchiveList is the list of default archives
@@ -82,6 +182,27 @@ var (
 		"tar.xz",
 		"tar.lz4",
 		"tar.sz",
+		"tar.zst",
+		"deb",
+		"rpm",
+		"apk",
+		"pacman",
+	}
+
+	// ArchiveExtensions maps the archives to their file extensions, where
+	// the two differ
+	ArchiveExtensions = map[string]string{
+		"pacman": "pkg.tar.zst",
+	}
+
+	// ArchiveAliases maps the short archive names to their full names
+	ArchiveAliases = map[string]string{
+		"tbz2": "tar.bz2",
+		"tgz":  "tar.gz",
+		"tlz4": "tar.lz4",
+		"tsz":  "tar.sz",
+		"txz":  "tar.xz",
+		"tzst": "tar.zst",
 	}
 )
 
@@ -107,6 +228,15 @@ func GetUserOSs(userOS []string) ([]string, error) {
 	return cleanList, nil
 }
 
+// CanonicalArchive returns the full lower case name of an archive format
+func CanonicalArchive(archive string) string {
+	lowerArchive := strings.ToLower(archive)
+	if fullName, ok := ArchiveAliases[lowerArchive]; ok {
+		return fullName
+	}
+	return lowerArchive
+}
+
 // GetUserArchives generates a list of valid archive types from the user defined list
 func GetUserArchives(userArchive []string) ([]string, error) {
 	cleanList := splitListItems(userArchive)
@@ -118,8 +248,9 @@ func GetUserArchives(userArchive []string) ([]string, error) {
 
 	validArchives := []string{}
 	for _, archive := range cleanList {
+		lowerArchive := CanonicalArchive(archive)
 		for _, dArchive := range ArchiveList {
-			if strings.ToLower(archive) == dArchive {
+			if lowerArchive == dArchive {
 				validArchives = append(validArchives, archive)
 				break
 			}
@@ -129,6 +260,112 @@ func GetUserArchives(userArchive []string) ([]string, error) {
 	return validArchives, nil
 }
 
+// GetUserFileModes generates a list of file mode overrides from the user
+// defined list
+func GetUserFileModes(userModes []string) ([]FileMode, error) {
+	modes := []FileMode{}
+	for _, userMode := range splitListItems(userModes) {
+		if userMode == "" {
+			continue
+		}
+		mode, err := ParseFileMode(userMode)
+		if err != nil {
+			return nil, err
+		}
+		modes = append(modes, mode)
+	}
+	return modes, nil
+}
+
+// MatchFileMode returns the mode of the last override matching the file path
+// or its base name. If no overrides match, the default mode is returned.
+func MatchFileMode(modes []FileMode, filePath string, defaultMode os.FileMode) os.FileMode {
+	for _, mode := range modes {
+		if MatchFilePattern(mode.Pattern, filePath) {
+			defaultMode = mode.Mode
+		}
+	}
+	return defaultMode
+}
+
+// ParseCompressionLevel parses the compression level of an archive format.
+// The level may be a number, or one of "fastest", "best" or "store".
+func ParseCompressionLevel(archive string, level string) (int, error) {
+	archive = CanonicalArchive(archive)
+	levels, ok := CompressionRanges[archive]
+	if !ok && archive == "tar.zst" {
+		return 0, errors.New("the tar.zst compression level is set with zstd-level")
+	} else if !ok {
+		return 0, errors.Errorf("archive '%s' does not support compression levels", archive)
+	}
+
+	switch strings.ToLower(level) {
+	case "fastest":
+		return levels.Fastest, nil
+	case "best":
+		return levels.Best, nil
+	case "store", "none":
+		if levels.Store == nil {
+			return 0, errors.Errorf("archive '%s' can not be stored without compression", archive)
+		}
+		return *levels.Store, nil
+	}
+
+	value, err := strconv.Atoi(level)
+	if err != nil {
+		return 0, errors.Errorf("could not parse compression level '%s'", level)
+	}
+	isStore := levels.Store != nil && value == *levels.Store
+	if !isStore && (value < levels.Fastest || value > levels.Best) {
+		return 0, errors.Errorf("compression level for '%s' must be between %d and %d",
+			archive, levels.Fastest, levels.Best)
+	}
+	return value, nil
+}
+
+// GetUserCompressionLevels generates the compression level of each archive
+// format from the config section and the user defined list. The list values
+// are either "archive=level", or a bare level that is used for every archive
+// that supports it. The list takes precedence over the config section.
+func GetUserCompressionLevels(configLevels map[string]string,
+	userLevels []string) (map[string]int, error) {
+	levels := map[string]int{}
+	for archive, level := range configLevels {
+		value, err := ParseCompressionLevel(archive, level)
+		if err != nil {
+			return nil, err
+		}
+		levels[CanonicalArchive(archive)] = value
+	}
+
+	for _, userLevel := range splitListItems(userLevels) {
+		if userLevel == "" {
+			continue
+		}
+		parts := strings.SplitN(userLevel, "=", 2)
+		if len(parts) == 2 {
+			value, err := ParseCompressionLevel(parts[0], parts[1])
+			if err != nil {
+				return nil, err
+			}
+			levels[CanonicalArchive(parts[0])] = value
+			continue
+		}
+
+		found := false
+		for archive := range CompressionRanges {
+			if value, err := ParseCompressionLevel(archive, userLevel); err == nil {
+				levels[archive] = value
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("invalid compression level '%s'", userLevel)
+		}
+	}
+	return levels, nil
+}
+
 func GetUserPackages(userPkgs []string) ([]Package, error) {
 	pkgs := []Package{}
 	userPkgs = splitListItems(userPkgs)
@@ -155,6 +392,9 @@ func AssemblePackageInfo(userArch []string, userOS []string,
 		for _, os := range osList {
 			for _, archive := range archiveList {
 				pkg := Package{Arch: arch, OS: os, Archive: archive}
+				if !IsValidPlatform(os, arch) || !IsSupportedPackage(pkg) {
+					continue
+				}
 				packageList = appendIfMissing(packageList, pkg)
 			}
 		}
@@ -172,9 +412,30 @@ func AssemblePackageInfo(userArch []string, userOS []string,
 	return packageList, nil
 }
 
-// GetPackagePaths generates info about the archives
+// IsRequestedPackage checks if the package was explicitly asked for by the
+// user. A package is requested if it is listed in the user packages, or if
+// both its OS and arch are listed in the user OS and arch lists. Negations
+// and default lists do not count as a request.
+func IsRequestedPackage(pkg Package, userArch []string, userOS []string,
+	userPackages []string) bool {
+	match := strings.ToLower(pkg.String())
+	specificList, _ := GetUserPackages(userPackages)
+	for _, userPkg := range specificList {
+		if strings.ToLower(userPkg.String()) == match {
+			return true
+		}
+	}
+
+	archList, _ := splitNegatedItems(splitListItems(userArch))
+	osList, _ := splitNegatedItems(splitListItems(userOS))
+	return containsItem(archList, pkg.Arch) && containsItem(osList, pkg.OS)
+}
+
+// GetPackagePaths generates info about the archives. The replacements rename
+// the OS & Arch values given to the output template, e.g. "amd64" to
+// "x86_64", while the input template always uses the Go names.
 func GetPackagePaths(packages []Package, dirs []string, inputTemplate string,
-	outputTemplate string) ([]Package, error) {
+	outputTemplate string, replacements map[string]string) ([]Package, error) {
 	filledPackages := []Package{}
 	for _, pkg := range packages {
 		for _, path := range dirs {
@@ -182,10 +443,13 @@ func GetPackagePaths(packages []Package, dirs []string, inputTemplate string,
 				Dir:     filepath.Base(path),
 				OS:      pkg.OS,
 				Arch:    pkg.Arch,
+				Variant: pkg.Variant,
 				Archive: pkg.Archive,
+
+				BuildInfo: pkg.BuildInfo,
 			}
 
-			inputTpl, err := template.New("input").Parse(inputTemplate)
+			inputTpl, err := newTemplate("input", inputTemplate)
 			if err != nil {
 				return nil, errors.Wrap(err, "input template error")
 			}
@@ -198,13 +462,16 @@ func GetPackagePaths(packages []Package, dirs []string, inputTemplate string,
 				filledPkg.ExePath = fmt.Sprintf("%s.exe", filledPkg.ExePath)
 			}
 
-			outputTpl, err := template.New("output").Parse(outputTemplate)
+			outputTpl, err := newTemplate("output", outputTemplate)
 			if err != nil {
 				return nil, errors.Wrap(err, "output template error")
 			}
 
+			outputPkg := filledPkg
+			outputPkg.OS = replaceName(replacements, filledPkg.OS)
+			outputPkg.Arch = replaceName(replacements, filledPkg.Arch)
 			var outputPath bytes.Buffer
-			if err := outputTpl.Execute(&outputPath, &filledPkg); err != nil {
+			if err := outputTpl.Execute(&outputPath, &outputPkg); err != nil {
 				return nil, errors.Wrap(err, "error generating output path")
 			}
 			filledPkg.ArchivePath = outputPath.String()
@@ -215,12 +482,99 @@ func GetPackagePaths(packages []Package, dirs []string, inputTemplate string,
 	return filledPackages, nil
 }
 
-func GetPackageFiles(packages []Package, fileList []string) ([]Package, error) {
+// CheckArchivePaths checks that no two packages are written to the same
+// archive path
+func CheckArchivePaths(packages []Package) error {
+	seen := map[string]Package{}
+	for _, pkg := range packages {
+		archivePath := filepath.Clean(pkg.ArchivePath)
+		if other, ok := seen[archivePath]; ok {
+			return errors.Errorf("%s and %s would both be written to %s",
+				other.String(), pkg.String(), pkg.ArchivePath)
+		}
+		seen[archivePath] = pkg
+	}
+	return nil
+}
+
+// GetPackageRoots generates the directory that the files of each package are
+// placed under inside of the archive
+func GetPackageRoots(packages []Package, rootTemplate string) ([]Package, error) {
+	rootTpl, err := newTemplate("root", rootTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "archive root template error")
+	}
+
 	pkgs := []Package{}
-	fileList = splitListItems(fileList)
 	for _, pkg := range packages {
-		files := append([]string{pkg.ExePath}, fileList...)
+		var rootPath bytes.Buffer
+		if err := rootTpl.Execute(&rootPath, &pkg); err != nil {
+			return nil, errors.Wrap(err, "error generating archive root")
+		}
+		pkg.ArchiveRoot = strings.Trim(filepath.ToSlash(rootPath.String()), "/")
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// GetPackageBinaryNames generates the name of the executable inside of the
+// archive for each package. Windows executables are given an ".exe" extension.
+func GetPackageBinaryNames(packages []Package, nameTemplate string) ([]Package, error) {
+	nameTpl, err := newTemplate("binary", nameTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "binary name template error")
+	}
+
+	pkgs := []Package{}
+	for _, pkg := range packages {
+		var binaryName bytes.Buffer
+		if err := nameTpl.Execute(&binaryName, &pkg); err != nil {
+			return nil, errors.Wrap(err, "error generating binary name")
+		}
+		pkg.BinaryName = binaryName.String()
+		if strings.ToLower(pkg.OS) == "windows" &&
+			!strings.HasSuffix(strings.ToLower(pkg.BinaryName), ".exe") {
+			pkg.BinaryName = fmt.Sprintf("%s.exe", pkg.BinaryName)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// GetPackageFiles sets the file list of each package to its executable and
+// the additional files meant for it, with the file path templates filled in.
+// Paths beginning with "!" are added to the package excludes instead. The
+// files are expanded later on by a FileResolver.
+func GetPackageFiles(packages []Package, fileSpecs []FileSpec) ([]Package, error) {
+	pathTpls := []*template.Template{}
+	for _, spec := range fileSpecs {
+		pathTpl, err := newTemplate("file", spec.Path)
+		if err != nil {
+			return nil, errors.Wrap(err, "file template error")
+		}
+		pathTpls = append(pathTpls, pathTpl)
+	}
+
+	pkgs := []Package{}
+	for _, pkg := range packages {
+		files := []string{pkg.ExePath}
+		excludes := []string{}
+		for i, spec := range fileSpecs {
+			if !spec.Matches(pkg) {
+				continue
+			}
+			var filePath bytes.Buffer
+			if err := pathTpls[i].Execute(&filePath, &pkg); err != nil {
+				return nil, errors.Wrap(err, "error generating file path")
+			}
+			if path := filePath.String(); strings.HasPrefix(path, "!") {
+				excludes = append(excludes, path[1:])
+			} else {
+				files = append(files, path)
+			}
+		}
 		pkg.FileList = files
+		pkg.Excludes = excludes
 		pkgs = append(pkgs, pkg)
 	}
 	return pkgs, nil
@@ -264,6 +618,15 @@ func GetAppDirs(packages []string) ([]string, error) {
 	return results, nil
 }
 
+// replaceName returns the replacement for the name, or the name itself if
+// there is none
+func replaceName(replacements map[string]string, name string) string {
+	if replacement, ok := replacements[strings.ToLower(name)]; ok {
+		return replacement
+	}
+	return name
+}
+
 func splitListItems(list []string) []string {
 	cleanList := []string{}
 	for _, item := range list {
@@ -309,6 +672,16 @@ func negateList(pList []string, nList []string) []string {
 	return finalList
 }
 
+func containsItem(list []string, item string) bool {
+	lowerItem := strings.ToLower(item)
+	for _, existing := range list {
+		if strings.ToLower(existing) == lowerItem {
+			return true
+		}
+	}
+	return false
+}
+
 func removeIfPresent(pkgs []Package, pkg Package) []Package {
 	match := strings.ToLower(pkg.String())
 	result := []Package{}
@@ -336,6 +709,7 @@ func appendIfMissing(pkgs []Package, pkg Package) []Package {
 }
 
 // NOTE: The original code can be found at the gox repo
+//
 //	https://raw.githubusercontent.com/mitchellh/gox/master/go.go
 func execGo(GoCmd string, env []string, dir string, args ...string) (string, error) {
 	var stderr, stdout bytes.Buffer
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -39,7 +40,8 @@ func TestGetUserArchs_WithDelimiters(t *testing.T) {
 }
 
 func TestGetUserArchs_DefaultNegations(t *testing.T) {
-	testArchs := []string{"!amd64p32", "!ppc64le"}
+	testArchs := []string{"!loong64", "!mips", "!mips64", "!mips64le", "!mipsle",
+		"!ppc64le", "!riscv64", "!s390x", "!wasm"}
 	results, err := GetUserArchs(testArchs)
 	assert.NoError(t, err, "unexpected error")
 
@@ -82,7 +84,8 @@ func TestGetUserOSs_WithDelimiters(t *testing.T) {
 }
 
 func TestGetUserOSs_DefaultNegations(t *testing.T) {
-	testOSs := []string{"!dragonfly", "!netbsd", "!openbsd", "!plan9", "!solaris"}
+	testOSs := []string{"!aix", "!android", "!dragonfly", "!illumos", "!ios", "!js",
+		"!netbsd", "!openbsd", "!plan9", "!solaris", "!wasip1"}
 	results, err := GetUserOSs(testOSs)
 	assert.NoError(t, err, "unexpected error")
 
@@ -121,7 +124,16 @@ func TestGetUserArchives_DefaultNegations(t *testing.T) {
 	results, err := GetUserArchives(testArchives)
 	assert.NoError(t, err, "unexpected error")
 
-	expected := []string{"tar.gz", "tar.xz", "tar.lz4", "tar.sz"}
+	expected := []string{"tar.gz", "tar.xz", "tar.lz4", "tar.sz", "tar.zst", "deb", "rpm", "apk", "pacman"}
+	assert.Equal(t, expected, results, "archive results do not match")
+}
+
+func TestGetUserArchives_Aliases(t *testing.T) {
+	testArchives := []string{"tgz", "TXZ", "tzst", "tar.zst", "trar"}
+	results, err := GetUserArchives(testArchives)
+	assert.NoError(t, err, "unexpected error")
+
+	expected := []string{"tgz", "TXZ", "tzst", "tar.zst"}
 	assert.Equal(t, expected, results, "archive results do not match")
 }
 
@@ -163,6 +175,36 @@ func TestGetUserPackages_WithDelimiters(t *testing.T) {
 	assert.Contains(t, results, pkg2, "missing expected package")
 }
 
+func TestParsePackage_Variant(t *testing.T) {
+	pkg, err := ParsePackage("linux/arm/v7/tar.gz")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, Package{OS: "linux", Arch: "arm", Variant: "v7", Archive: "tar.gz"}, pkg,
+		"package does not match")
+	assert.Equal(t, "linux/arm/v7/tar.gz", pkg.String(), "package string does not match")
+
+	pkg, err = ParsePackage("linux/amd64/3/zip")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, "v3", pkg.Variant, "variant does not match")
+
+	pkg, err = ParsePackage("linux/mipsle/softfloat/tar.gz")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, "softfloat", pkg.Variant, "variant does not match")
+
+	_, err = ParsePackage("linux/arm64/v7/tar.gz")
+	assert.Error(t, err, "expected an error for an unknown variant")
+}
+
+func TestAssemblePackageInfo_Variants(t *testing.T) {
+	results, err := AssemblePackageInfo([]string{"arm"}, []string{"linux"}, []string{"zip"},
+		[]string{"linux/arm/v6/zip", "linux/arm/v7/zip", "!linux/arm/zip"})
+	assert.NoError(t, err, "unexpected error")
+	expected := []Package{
+		Package{OS: "linux", Arch: "arm", Variant: "v6", Archive: "zip"},
+		Package{OS: "linux", Arch: "arm", Variant: "v7", Archive: "zip"},
+	}
+	assert.Equal(t, expected, results, "packages do not match")
+}
+
 func TestGetUserPackages_InvalidPackage(t *testing.T) {
 	results, err := GetUserPackages([]string{"linux/amd64"})
 	assert.NoError(t, err, "unexpected error")
@@ -170,11 +212,90 @@ func TestGetUserPackages_InvalidPackage(t *testing.T) {
 	assert.Len(t, results, 0, "incorrect number of packages")
 }
 
+func TestGetUserFileModes(t *testing.T) {
+	results, err := GetUserFileModes([]string{"install.sh=0755 docs/*.md=600", "LICENSE=0444"})
+	assert.NoError(t, err, "unexpected error")
+
+	expected := []FileMode{
+		FileMode{Pattern: "install.sh", Mode: 0755},
+		FileMode{Pattern: "docs/*.md", Mode: 0600},
+		FileMode{Pattern: "LICENSE", Mode: 0444},
+	}
+	assert.Equal(t, expected, results, "file mode results do not match")
+}
+
+func TestGetUserFileModes_Invalid(t *testing.T) {
+	_, err := GetUserFileModes([]string{"install.sh"})
+	assert.Error(t, err, "expected error")
+
+	_, err = GetUserFileModes([]string{"install.sh=0799"})
+	assert.Error(t, err, "expected error")
+
+	_, err = GetUserFileModes([]string{"install.sh=4755"})
+	assert.Error(t, err, "expected error")
+}
+
+func TestMatchFileMode(t *testing.T) {
+	modes := []FileMode{
+		FileMode{Pattern: "*.sh", Mode: 0700},
+		FileMode{Pattern: "scripts/install.sh", Mode: 0755},
+	}
+
+	assert.Equal(t, os.FileMode(0755), MatchFileMode(modes, "./scripts/install.sh", 0644),
+		"file mode does not match")
+	assert.Equal(t, os.FileMode(0700), MatchFileMode(modes, "other/run.sh", 0644),
+		"file mode does not match")
+	assert.Equal(t, os.FileMode(0644), MatchFileMode(modes, "README.md", 0644),
+		"file mode does not match")
+}
+
+func TestGetUserCompressionLevels(t *testing.T) {
+	results, err := GetUserCompressionLevels(map[string]string{"tar.xz": "9", "zip": "store"},
+		[]string{"tgz=best"})
+	assert.NoError(t, err, "unexpected error")
+
+	expected := map[string]int{"tar.xz": 9, "zip": 0, "tar.gz": 9}
+	assert.Equal(t, expected, results, "compression level results do not match")
+}
+
+func TestGetUserCompressionLevels_Precedence(t *testing.T) {
+	results, err := GetUserCompressionLevels(map[string]string{"zip": "9"}, []string{"zip=1"})
+	assert.NoError(t, err, "unexpected error")
+
+	assert.Equal(t, map[string]int{"zip": 1}, results, "compression level results do not match")
+}
+
+func TestGetUserCompressionLevels_AllArchives(t *testing.T) {
+	results, err := GetUserCompressionLevels(map[string]string{}, []string{"fastest"})
+	assert.NoError(t, err, "unexpected error")
+
+	assert.Len(t, results, len(CompressionRanges), "incorrect number of compression levels")
+	assert.Equal(t, 1, results["zip"], "compression level does not match")
+	assert.Equal(t, 0, results["tar.lz4"], "compression level does not match")
+}
+
+func TestGetUserCompressionLevels_Invalid(t *testing.T) {
+	_, err := GetUserCompressionLevels(map[string]string{"tar.sz": "9"}, []string{})
+	assert.Error(t, err, "expected error")
+
+	_, err = GetUserCompressionLevels(map[string]string{}, []string{"tar.xz=store"})
+	assert.Error(t, err, "expected error")
+
+	_, err = GetUserCompressionLevels(map[string]string{}, []string{"tzst=19"})
+	assert.Error(t, err, "expected error")
+
+	_, err = GetUserCompressionLevels(map[string]string{}, []string{"tar.gz=10"})
+	assert.Error(t, err, "expected error")
+
+	_, err = GetUserCompressionLevels(map[string]string{}, []string{"fast"})
+	assert.Error(t, err, "expected error")
+}
+
 func TestAssemblePackageInfo_DefaultList(t *testing.T) {
 	results, err := AssemblePackageInfo([]string{}, []string{}, []string{}, []string{})
 	assert.NoError(t, err, "unexpected error")
 
-	assert.Equal(t, 441, len(results), "package results do not match")
+	assert.Equal(t, 1696, len(results), "package results do not match")
 }
 
 func TestAssemblePackageInfo_SingleAssembled(t *testing.T) {
@@ -231,7 +352,7 @@ func TestAssemblePackageInfo_OnlyNegate(t *testing.T) {
 	results, err := AssemblePackageInfo([]string{}, []string{}, []string{},
 		[]string{"!linux/arm/tar.xz", "!darwin/arm/tar.gz"})
 	assert.NoError(t, err, "unexpected error")
-	assert.Len(t, results, 439, "unexpected number of results")
+	assert.Len(t, results, 1694, "unexpected number of results")
 	assert.NotContains(t, results, Package{Arch: "arm", OS: "linux", Archive: "tar.xz"},
 		"negated package found in results")
 	assert.NotContains(t, results, Package{Arch: "arm", OS: "darwin", Archive: "tar.gz"},
@@ -248,13 +369,31 @@ func TestAssemblePackageInfo_Precedence(t *testing.T) {
 		"negated package found in results")
 }
 
+func TestIsRequestedPackage_UserPackages(t *testing.T) {
+	pkg := Package{Arch: "amd64", OS: "linux", Archive: "tar.xz"}
+	assert.True(t, IsRequestedPackage(pkg, []string{}, []string{},
+		[]string{"linux/arm/zip", "Linux/AMD64/tar.xz"}), "package should be requested")
+	assert.False(t, IsRequestedPackage(pkg, []string{}, []string{},
+		[]string{"!linux/amd64/tar.xz"}), "negated package should not be requested")
+}
+
+func TestIsRequestedPackage_UserLists(t *testing.T) {
+	pkg := Package{Arch: "amd64", OS: "linux", Archive: "tar.xz"}
+	assert.True(t, IsRequestedPackage(pkg, []string{"386 amd64"}, []string{"linux,darwin"},
+		[]string{}), "package should be requested")
+	assert.False(t, IsRequestedPackage(pkg, []string{}, []string{"linux"},
+		[]string{}), "package without arch should not be requested")
+	assert.False(t, IsRequestedPackage(pkg, []string{"!arm"}, []string{"linux"},
+		[]string{}), "package with only negated arch should not be requested")
+}
+
 func TestGetPackagePaths(t *testing.T) {
 	pkgs := []Package{Package{OS: "linux", Arch: "x64", Archive: "tgz"}}
 	dirs := []string{"/this/is/a/test", "/another/test/exe"}
 	inputTemplate := "test/{{.Dir}}-{{.OS}}-{{.Arch}}"
 	outputTemplate := "test/{{.Dir}}-{{.OS}}-{{.Arch}}.{{.Archive}}"
 
-	result, err := GetPackagePaths(pkgs, dirs, inputTemplate, outputTemplate)
+	result, err := GetPackagePaths(pkgs, dirs, inputTemplate, outputTemplate, nil)
 	assert.NoError(t, err, "unexpected error")
 
 	assert.Len(t, result, 2, "incorrect number of packaged results")
@@ -272,16 +411,142 @@ func TestGetPackagePaths(t *testing.T) {
 	assert.Equal(t, expected, result[1], "package results do not match")
 }
 
+func TestGetPackagePaths_Ext(t *testing.T) {
+	pkgs := []Package{
+		Package{OS: "linux", Arch: "amd64", Archive: "pacman"},
+		Package{OS: "linux", Arch: "amd64", Archive: "tgz"},
+	}
+
+	result, err := GetPackagePaths(pkgs, []string{"/test/exe"}, "{{.Dir}}",
+		"{{.Dir}}_{{.OS}}_{{.Arch}}.{{.Ext}}", nil)
+	assert.NoError(t, err, "unexpected error")
+	assert.Len(t, result, 2, "incorrect number of packaged results")
+	assert.Equal(t, "exe_linux_amd64.pkg.tar.zst", result[0].ArchivePath, "archive path does not match")
+	assert.Equal(t, "exe_linux_amd64.tgz", result[1].ArchivePath, "archive path does not match")
+}
+
+func TestGetPackagePaths_Replacements(t *testing.T) {
+	pkgs := []Package{Package{OS: "darwin", Arch: "amd64", Archive: "zip"}}
+	replacements := map[string]string{"darwin": "macOS", "amd64": "x86_64"}
+
+	result, err := GetPackagePaths(pkgs, []string{"/test/exe"}, "{{.Dir}}-{{.OS}}-{{.Arch}}",
+		"{{.Dir}}-{{.OS}}-{{.Arch}}.{{.Archive}}", replacements)
+	assert.NoError(t, err, "unexpected error")
+	assert.Len(t, result, 1, "incorrect number of packaged results")
+
+	expected := pkgs[0]
+	expected.Dir = "exe"
+	expected.ExePath = "exe-darwin-amd64"
+	expected.ArchivePath = "exe-macOS-x86_64.zip"
+	assert.Equal(t, expected, result[0], "package results do not match")
+}
+
+func TestCheckArchivePaths(t *testing.T) {
+	pkgs := []Package{
+		Package{OS: "linux", Arch: "arm", Archive: "zip"},
+		Package{OS: "linux", Arch: "arm", Variant: "v6", Archive: "zip"},
+		Package{OS: "linux", Arch: "arm", Variant: "v7", Archive: "zip"},
+	}
+
+	result, err := GetPackagePaths(pkgs, []string{"/test/vt"}, "{{.Dir}}_{{.OS}}_{{.Arch}}{{.Variant}}",
+		"{{.Dir}}_{{.OS}}_{{.Arch}}{{.Variant}}.{{.Ext}}", nil)
+	assert.NoError(t, err, "unexpected error")
+	assert.NoError(t, CheckArchivePaths(result), "unexpected error")
+	assert.Equal(t, "vt_linux_armv7.zip", result[2].ArchivePath, "archive path does not match")
+
+	result, err = GetPackagePaths(pkgs, []string{"/test/vt"}, "{{.Dir}}_{{.OS}}_{{.Arch}}",
+		"{{.Dir}}_{{.OS}}_{{.Arch}}.{{.Ext}}", nil)
+	assert.NoError(t, err, "unexpected error")
+	assert.EqualError(t, CheckArchivePaths(result),
+		"linux/arm/zip and linux/arm/v6/zip would both be written to vt_linux_arm.zip")
+}
+
 func TestGetPackageFiles(t *testing.T) {
 	pkgs := []Package{Package{OS: "linux", Arch: "x64", Archive: "tgz", ExePath: "bin/exe-linux-x64"}}
-	fileList := []string{"readme.md", "license", "test/file"}
+	fileSpecs := []FileSpec{{Path: "readme.md"}, {Path: "license"}, {Path: "test/file"}}
 
-	result, err := GetPackageFiles(pkgs, fileList)
+	result, err := GetPackageFiles(pkgs, fileSpecs)
 	assert.NoError(t, err, "unexpected error")
 	assert.Len(t, result, 1, "incorrect number of packaged results")
 
 	expected := pkgs[0]
 	expected.FileList = []string{"bin/exe-linux-x64", "readme.md", "license", "test/file"}
+	expected.Excludes = []string{}
 
 	assert.Equal(t, expected, result[0], "package results do not match")
 }
+
+func TestGetPackageFiles_Scoped(t *testing.T) {
+	pkgs := []Package{
+		Package{OS: "linux", Arch: "amd64", Archive: "tar.gz", ExePath: "bin/exe-linux"},
+		Package{OS: "windows", Arch: "amd64", Archive: "zip", ExePath: "bin/exe-windows.exe"},
+	}
+	fileSpecs := []FileSpec{
+		{Path: "readme.md"},
+		{Path: "completions/{{.OS}}", Packages: []string{"linux", "darwin"}},
+		{Path: "install.ps1", Packages: []string{"windows/*/zip"}},
+		{Path: "!*.bak", Packages: []string{"!windows"}},
+	}
+
+	result, err := GetPackageFiles(pkgs, fileSpecs)
+	assert.NoError(t, err, "unexpected error")
+	assert.Len(t, result, 2, "incorrect number of packaged results")
+
+	assert.Equal(t, []string{"bin/exe-linux", "readme.md", "completions/linux"},
+		result[0].FileList, "linux files do not match")
+	assert.Equal(t, []string{"*.bak"}, result[0].Excludes, "linux excludes do not match")
+	assert.Equal(t, []string{"bin/exe-windows.exe", "readme.md", "install.ps1"},
+		result[1].FileList, "windows files do not match")
+	assert.Equal(t, []string{}, result[1].Excludes, "windows excludes do not match")
+}
+
+func TestGetPackageRoots(t *testing.T) {
+	pkgs := []Package{Package{Dir: "exe", OS: "linux", Arch: "x64", Archive: "tgz"}}
+
+	result, err := GetPackageRoots(pkgs, "{{.Dir}}-{{.OS}}-{{.Arch}}/")
+	assert.NoError(t, err, "unexpected error")
+	assert.Len(t, result, 1, "incorrect number of packaged results")
+
+	expected := pkgs[0]
+	expected.ArchiveRoot = "exe-linux-x64"
+	assert.Equal(t, expected, result[0], "package results do not match")
+}
+
+func TestGetPackageRoots_Empty(t *testing.T) {
+	pkgs := []Package{Package{Dir: "exe", OS: "linux", Arch: "x64", Archive: "tgz"}}
+
+	result, err := GetPackageRoots(pkgs, "")
+	assert.NoError(t, err, "unexpected error")
+
+	assert.Equal(t, pkgs, result, "package results do not match")
+}
+
+func TestGetPackageBinaryNames(t *testing.T) {
+	pkgs := []Package{
+		Package{Dir: "exe", OS: "linux", Arch: "x64", Archive: "tgz"},
+		Package{Dir: "exe", OS: "windows", Arch: "x64", Archive: "zip"},
+	}
+
+	result, err := GetPackageBinaryNames(pkgs, "{{.Dir}}")
+	assert.NoError(t, err, "unexpected error")
+	assert.Len(t, result, 2, "incorrect number of packaged results")
+
+	assert.Equal(t, "exe", result[0].BinaryName, "binary name does not match")
+	assert.Equal(t, "exe.exe", result[1].BinaryName, "binary name does not match")
+}
+
+func TestGetPackageBinaryNames_WithExtension(t *testing.T) {
+	pkgs := []Package{Package{Dir: "exe", OS: "windows", Arch: "x64", Archive: "zip"}}
+
+	result, err := GetPackageBinaryNames(pkgs, "{{.Dir}}-{{.Arch}}.exe")
+	assert.NoError(t, err, "unexpected error")
+
+	assert.Equal(t, "exe-x64.exe", result[0].BinaryName, "binary name does not match")
+}
+
+func TestAssemblePackageInfo_LinuxPackages(t *testing.T) {
+	results, _ := AssemblePackageInfo([]string{"amd64", "ppc64"},
+		[]string{"linux", "windows"}, []string{"deb"}, []string{})
+	expected := []Package{{OS: "linux", Arch: "amd64", Archive: "deb"}}
+	assert.Equal(t, expected, results, "package results do not match")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// BuildInfo is the release info available to the path templates
+type BuildInfo struct {
+	Version string            `json:"version,omitempty"`
+	Commit  string            `json:"commit,omitempty"`
+	Date    string            `json:"date,omitempty"`
+	Env     map[string]string `json:"-"`
+}
+
+// GetBuildInfo gathers the release info of the project in the working
+// directory. If no version is given, it is found with "git describe". The
+// date is formatted as YYYY-MM-DD.
+func GetBuildInfo(version string, date time.Time) BuildInfo {
+	info := BuildInfo{
+		Version: version,
+		Date:    date.Format("2006-01-02"),
+		Env:     map[string]string{},
+	}
+	if info.Version == "" {
+		info.Version = gitOutput("describe", "--tags", "--always", "--dirty")
+	}
+	info.Commit = gitOutput("rev-parse", "HEAD")
+
+	for _, env := range os.Environ() {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) == 2 {
+			info.Env[parts[0]] = parts[1]
+		}
+	}
+	return info
+}
+
+// gitOutput runs git with the args and returns the trimmed output, or an
+// empty string if git failed
+func gitOutput(args ...string) string {
+	output, err := execGo("git", nil, "", args...)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(output)
+}
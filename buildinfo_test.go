@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBuildInfo(t *testing.T) {
+	os.Setenv("GOP_TEST_BUILD", "nightly")
+	defer os.Unsetenv("GOP_TEST_BUILD")
+
+	info := GetBuildInfo("1.2.3", time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC))
+	assert.Equal(t, "1.2.3", info.Version, "version does not match")
+	assert.Equal(t, "2020-03-04", info.Date, "date does not match")
+	assert.Equal(t, "nightly", info.Env["GOP_TEST_BUILD"], "env does not match")
+}
+
+func TestGetPackagePaths_BuildInfo(t *testing.T) {
+	pkgs := []Package{Package{OS: "linux", Arch: "amd64", Archive: "zip",
+		BuildInfo: BuildInfo{Version: "v1.0.0", Commit: "abc123", Date: "2020-03-04",
+			Env: map[string]string{"CHANNEL": "beta"}}}}
+
+	result, err := GetPackagePaths(pkgs, []string{"test/exe"}, "{{.Dir}}_{{.OS}}",
+		"{{.Dir}}_{{.Version}}_{{.Env.CHANNEL}}_{{.Date}}_{{.Commit}}.{{.Archive}}", nil)
+	assert.NoError(t, err, "unexpected error")
+	assert.Len(t, result, 1, "incorrect number of packaged results")
+	assert.Equal(t, "exe_v1.0.0_beta_2020-03-04_abc123.zip", result[0].ArchivePath,
+		"archive path does not match")
+}
+
+func TestGetPackagePaths_MissingEnv(t *testing.T) {
+	pkgs := []Package{Package{OS: "linux", Arch: "amd64", Archive: "zip",
+		BuildInfo: BuildInfo{Env: map[string]string{}}}}
+
+	result, err := GetPackagePaths(pkgs, []string{"test/exe"}, "{{.Dir}}",
+		"{{.Dir}}{{.Env.GOP_TEST_MISSING}}.{{.Archive}}", nil)
+	assert.NoError(t, err, "unexpected error")
+	assert.Len(t, result, 1, "incorrect number of packaged results")
+	assert.Equal(t, "exe.zip", result[0].ArchivePath, "archive path does not match")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+// ChecksumList is the full list of supported checksum algorithms
+var ChecksumList = []string{
+	"sha256",
+	"sha512",
+	"sha1",
+	"md5",
+	"blake2b",
+}
+
+// NewChecksumHash returns a new hash for the given checksum algorithm
+func NewChecksumHash(algorithm string) (hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "md5":
+		return md5.New(), nil
+	case "blake2b":
+		return blake2b.New512(nil)
+	default:
+		return nil, errors.Errorf("unknown checksum algorithm '%s'", algorithm)
+	}
+}
+
+// GetChecksumFiles groups the packages by the checksum file they belong to.
+// The returned paths are in the order they were first seen.
+func GetChecksumFiles(packages []Package, checksumTemplate string) ([]string, map[string][]Package, error) {
+	checksumTpl, err := newTemplate("checksum", checksumTemplate)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "checksum template error")
+	}
+
+	paths := []string{}
+	groups := map[string][]Package{}
+	for _, pkg := range packages {
+		var checksumPath bytes.Buffer
+		if err := checksumTpl.Execute(&checksumPath, &pkg); err != nil {
+			return nil, nil, errors.Wrap(err, "error generating checksum path")
+		}
+		path := checksumPath.String()
+		if _, ok := groups[path]; !ok {
+			paths = append(paths, path)
+		}
+		groups[path] = append(groups[path], pkg)
+	}
+	return paths, groups, nil
+}
+
+// WriteChecksumFile writes the package checksums to path in the same format
+// used by the sha256sum family of tools. Archive paths are written relative to
+// the checksum file so the file can be published alongside the archives.
+func WriteChecksumFile(path string, packages []Package) error {
+	var sums bytes.Buffer
+	for _, pkg := range packages {
+		name, err := filepath.Rel(filepath.Dir(path), pkg.ArchivePath)
+		if err != nil {
+			name = pkg.ArchivePath
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", pkg.Checksum, filepath.ToSlash(name))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "making checksum folder")
+	}
+	if err := ioutil.WriteFile(path, sums.Bytes(), 0644); err != nil {
+		return errors.Wrapf(err, "writing %s", path)
+	}
+	return nil
+}
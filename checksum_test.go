@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewChecksumHash(t *testing.T) {
+	for _, algorithm := range ChecksumList {
+		hash, err := NewChecksumHash(algorithm)
+		assert.NoError(t, err, "unexpected error")
+		assert.NotNil(t, hash, "missing hash for %s", algorithm)
+	}
+
+	hash, _ := NewChecksumHash("SHA256")
+	hash.Write([]byte("gop"))
+	assert.Equal(t, "fa3086798c6622b42928da40e2fe3464c482b8df4ce97a04edea3d81e83bc07f",
+		hex.EncodeToString(hash.Sum(nil)), "checksum does not match")
+}
+
+func TestNewChecksumHash_Unknown(t *testing.T) {
+	_, err := NewChecksumHash("crc32")
+	assert.Error(t, err, "expected error")
+}
+
+func TestGetChecksumFiles(t *testing.T) {
+	pkgs := []Package{
+		Package{Dir: "exe", OS: "linux", Arch: "amd64", Archive: "zip"},
+		Package{Dir: "test", OS: "linux", Arch: "amd64", Archive: "zip"},
+		Package{Dir: "exe", OS: "darwin", Arch: "amd64", Archive: "zip"},
+	}
+
+	paths, groups, err := GetChecksumFiles(pkgs, "dist/{{.Dir}}_checksums.txt")
+	assert.NoError(t, err, "unexpected error")
+
+	assert.Equal(t, []string{"dist/exe_checksums.txt", "dist/test_checksums.txt"}, paths,
+		"checksum paths do not match")
+	assert.Equal(t, []Package{pkgs[0], pkgs[2]}, groups["dist/exe_checksums.txt"],
+		"checksum group does not match")
+	assert.Equal(t, []Package{pkgs[1]}, groups["dist/test_checksums.txt"],
+		"checksum group does not match")
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mholt/archiver"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// CompressionRange is the range of compression levels supported by an
+// archive format
+type CompressionRange struct {
+	Fastest int
+	Best    int
+	// Store is the level that disables compression, if the format has one
+	Store *int
+}
+
+const (
+	// DefaultZstdLevel is the zstd compression level used when none is given
+	DefaultZstdLevel = 3
+	// MinZstdLevel is the fastest zstd compression level
+	MinZstdLevel = 1
+	// MaxZstdLevel is the best zstd compression level
+	MaxZstdLevel = 22
+)
+
+var noCompression = 0
+
+// CompressionRanges lists the archive formats that support compression
+// levels along with the levels they support. The tar.zst level is set on its
+// own with "--zstd-level".
+var CompressionRanges = map[string]CompressionRange{
+	"zip":     CompressionRange{Fastest: 1, Best: 9, Store: &noCompression},
+	"tar.gz":  CompressionRange{Fastest: 1, Best: 9, Store: &noCompression},
+	"tar.bz2": CompressionRange{Fastest: 1, Best: 9},
+	"tar.xz":  CompressionRange{Fastest: 0, Best: 9},
+	"tar.lz4": CompressionRange{Fastest: 0, Best: 16},
+}
+
+// xzDictCaps maps the xz compression levels to the dictionary sizes used by
+// the matching xz command presets
+var xzDictCaps = []int{
+	256 << 10,
+	1 << 20,
+	2 << 20,
+	4 << 20,
+	4 << 20,
+	8 << 20,
+	8 << 20,
+	16 << 20,
+	32 << 20,
+	64 << 20,
+}
+
+// CompressedTar facilitates tarball archives compressed by any writer
+type CompressedTar struct {
+	*archiver.Tar
+
+	newWriter func(io.Writer) (io.WriteCloser, error)
+	wc        io.WriteCloser
+	name      string
+}
+
+// Create opens ct for writing a compressed tar archive to out
+func (ct *CompressedTar) Create(out io.Writer) error {
+	wc, err := ct.newWriter(out)
+	if err != nil {
+		return errors.Wrapf(err, "creating %s writer", ct.name)
+	}
+	ct.wc = wc
+	return ct.Tar.Create(wc)
+}
+
+// Close closes the tar archive and flushes the compressed stream
+func (ct *CompressedTar) Close() error {
+	err := ct.Tar.Close()
+	if ct.wc != nil {
+		if cerr := ct.wc.Close(); err == nil {
+			err = cerr
+		}
+		ct.wc = nil
+	}
+	return err
+}
+
+func (ct *CompressedTar) String() string { return ct.name }
+
+// NewTarZst returns a zstandard compressed tar writer. The level is the one
+// used by the zstd command, mapped to the closest level the encoder supports.
+func NewTarZst(level int) *CompressedTar {
+	return &CompressedTar{
+		Tar:  archiver.NewTar(),
+		name: "tar.zst",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		},
+	}
+}
+
+// NewTarXz returns an xz compressed tar writer using the dictionary size of
+// the matching xz command preset
+func NewTarXz(level int) *CompressedTar {
+	return &CompressedTar{
+		Tar:  archiver.NewTar(),
+		name: "tar.xz",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return xz.WriterConfig{DictCap: xzDictCaps[level]}.NewWriter(w)
+		},
+	}
+}
+
+// StoredZip facilitates zip archives with every file stored without
+// compression. The archiver zip writer deflates every file, even at level 0.
+type StoredZip struct {
+	zw *zip.Writer
+}
+
+// NewStoredZip returns a zip writer that stores files without compression
+func NewStoredZip() *StoredZip {
+	return &StoredZip{}
+}
+
+// Create opens sz for writing a zip archive to out
+func (sz *StoredZip) Create(out io.Writer) error {
+	if sz.zw != nil {
+		return errors.New("zip archive is already created for writing")
+	}
+	sz.zw = zip.NewWriter(out)
+	return nil
+}
+
+// Write writes the file to sz without compressing it
+func (sz *StoredZip) Write(f archiver.File) error {
+	if sz.zw == nil {
+		return errors.New("zip archive was not created for writing first")
+	}
+	header, err := zip.FileInfoHeader(f)
+	if err != nil {
+		return errors.Wrapf(err, "%s: getting header", f.Name())
+	}
+	header.Method = zip.Store
+	if f.IsDir() {
+		header.Name += "/"
+	}
+
+	writer, err := sz.zw.CreateHeader(header)
+	if err != nil {
+		return errors.Wrapf(err, "%s: making header", f.Name())
+	}
+	if !header.Mode().IsRegular() {
+		return nil
+	}
+	if f.ReadCloser == nil {
+		return errors.Errorf("%s: no way to read file contents", f.Name())
+	}
+	_, err = io.Copy(writer, f)
+	return errors.Wrapf(err, "%s: copying contents", f.Name())
+}
+
+// Close finishes the zip archive
+func (sz *StoredZip) Close() error {
+	if sz.zw == nil {
+		return nil
+	}
+	zw := sz.zw
+	sz.zw = nil
+	return zw.Close()
+}
+
+func (sz *StoredZip) String() string { return "zip" }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DebArchs maps the Go architectures to the Debian architectures. GOARM 5
+// and 6 builds are soft float compatible, and go in armel packages.
+var DebArchs = map[string]string{
+	"amd64":   "amd64",
+	"386":     "i386",
+	"arm":     "armhf",
+	"arm/v5":  "armel",
+	"arm/v6":  "armel",
+	"arm64":   "arm64",
+	"ppc64le": "ppc64el",
+}
+
+// writeDeb writes a Debian binary package. It is an ar archive holding the
+// package format version, a control tarball with the package metadata, and a
+// data tarball with the installed files.
+func writeDeb(w io.Writer, pkg Package, opts ArchiveOptions) error {
+	info, arch, err := getLinuxPackageInfo(pkg, opts, DebArchs)
+	if err != nil {
+		return err
+	}
+	if info.Maintainer == "" {
+		return errors.New("deb.maintainer must be set in the config file")
+	}
+
+	modTime := linuxPackageModTime(opts)
+	files, err := getLinuxPackageEntries(pkg, info, opts)
+	if err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+	if err := writeDebTarGz(&data, addParentEntries(files, modTime)); err != nil {
+		return errors.Wrap(err, "creating data.tar.gz")
+	}
+
+	sums, err := debMD5Sums(files)
+	if err != nil {
+		return err
+	}
+	control := debControl(info, arch, installedSize(files))
+	var controlTar bytes.Buffer
+	err = writeDebTarGz(&controlTar, []archiveEntry{
+		metadataEntry("control", control, modTime),
+		metadataEntry("md5sums", sums, modTime),
+	})
+	if err != nil {
+		return errors.Wrap(err, "creating control.tar.gz")
+	}
+
+	ar := newArWriter(w)
+	ar.WriteFile("debian-binary", []byte("2.0\n"), modTime)
+	ar.WriteFile("control.tar.gz", controlTar.Bytes(), modTime)
+	ar.WriteFile("data.tar.gz", data.Bytes(), modTime)
+	return ar.err
+}
+
+// debControl generates the control file of the package. The first line of
+// the description is the synopsis, any further lines the long description.
+func debControl(info LinuxPackageInfo, arch string, size int64) []byte {
+	var control bytes.Buffer
+	fmt.Fprintf(&control, "Package: %s\n", info.Name)
+	fmt.Fprintf(&control, "Version: %s\n", info.Version)
+	fmt.Fprintf(&control, "Architecture: %s\n", arch)
+	fmt.Fprintf(&control, "Maintainer: %s\n", info.Maintainer)
+	// the size is in KiB, rounded up
+	fmt.Fprintf(&control, "Installed-Size: %d\n", (size+1023)/1024)
+	if len(info.Depends) > 0 {
+		fmt.Fprintf(&control, "Depends: %s\n", strings.Join(info.Depends, ", "))
+	}
+	if info.Section != "" {
+		fmt.Fprintf(&control, "Section: %s\n", info.Section)
+	}
+	if info.Priority != "" {
+		fmt.Fprintf(&control, "Priority: %s\n", info.Priority)
+	}
+	if info.Homepage != "" {
+		fmt.Fprintf(&control, "Homepage: %s\n", info.Homepage)
+	}
+
+	lines := strings.Split(strings.TrimSpace(info.Description), "\n")
+	fmt.Fprintf(&control, "Description: %s\n", strings.TrimSpace(lines[0]))
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = "."
+		}
+		fmt.Fprintf(&control, " %s\n", line)
+	}
+	return control.Bytes()
+}
+
+// debMD5Sums generates the md5sums file of the package
+func debMD5Sums(entries []archiveEntry) ([]byte, error) {
+	var sums bytes.Buffer
+	for _, entry := range entries {
+		file, err := openArchiveEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			continue
+		}
+		hash := md5.New()
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "hashing %s", entry.name)
+		}
+		fmt.Fprintf(&sums, "%x  %s\n", hash.Sum(nil), entry.name)
+	}
+	return sums.Bytes(), nil
+}
+
+// writeDebTarGz writes the entries to a gzipped tarball with the "./" prefix
+// dpkg uses for its own packages
+func writeDebTarGz(w io.Writer, entries []archiveEntry) error {
+	gz := gzip.NewWriter(w)
+	if err := writeLinuxTar(gz, entries, "./"); err != nil {
+		return err
+	}
+	return errors.Wrap(gz.Close(), "closing gzip")
+}
+
+// arWriter writes the common ar archive format used by Debian packages. The
+// first error is kept and any later writes are skipped.
+type arWriter struct {
+	w   io.Writer
+	err error
+}
+
+func newArWriter(w io.Writer) *arWriter {
+	a := &arWriter{w: w}
+	_, a.err = io.WriteString(w, "!<arch>\n")
+	return a
+}
+
+// WriteFile adds a file owned by root to the archive
+func (a *arWriter) WriteFile(name string, data []byte, modTime time.Time) {
+	if a.err != nil {
+		return
+	}
+	_, a.err = fmt.Fprintf(a.w, "%-16s%-12d%-6d%-6d%-8o%-10d`\n",
+		name, modTime.Unix(), 0, 0, 0100644, len(data))
+	if a.err == nil {
+		_, a.err = a.w.Write(data)
+	}
+	// file data is aligned to an even offset
+	if a.err == nil && len(data)%2 == 1 {
+		_, a.err = a.w.Write([]byte("\n"))
+	}
+	a.err = errors.Wrapf(a.err, "writing %s", name)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebControl(t *testing.T) {
+	info := LinuxPackageInfo{
+		Name:        "gop",
+		Version:     "1.0.0",
+		Maintainer:  "Jane Doe <jane@example.com>",
+		Description: "Go packager\nPackages executables.\n\nThe end.",
+		Depends:     []string{"libc6", "tar"},
+	}
+	expected := "Package: gop\n" +
+		"Version: 1.0.0\n" +
+		"Architecture: armhf\n" +
+		"Maintainer: Jane Doe <jane@example.com>\n" +
+		"Installed-Size: 2\n" +
+		"Depends: libc6, tar\n" +
+		"Description: Go packager\n" +
+		" Packages executables.\n" +
+		" .\n" +
+		" The end.\n"
+	assert.Equal(t, expected, string(debControl(info, DebArchs["arm"], 1025)), "control does not match")
+}
+
+func TestDebArchs(t *testing.T) {
+	opts := ArchiveOptions{PackageInfo: map[string]LinuxPackageInfo{"deb": {Version: "1.0.0"}}}
+	tests := map[string]string{"": "armhf", "v5": "armel", "v6": "armel", "v7": "armhf"}
+	for variant, expected := range tests {
+		pkg := Package{OS: "linux", Arch: "arm", Variant: variant, Archive: "deb"}
+		_, arch, err := getLinuxPackageInfo(pkg, opts, DebArchs)
+		assert.NoError(t, err, "unexpected error")
+		assert.Equal(t, expected, arch, "arm %s arch does not match", variant)
+		assert.True(t, IsSupportedPackage(pkg), "arm %s deb is not supported", variant)
+	}
+}
+
+func TestArWriter(t *testing.T) {
+	var out bytes.Buffer
+	ar := newArWriter(&out)
+	ar.WriteFile("debian-binary", []byte("2.0\n"), ReproducibleModTime)
+	ar.WriteFile("odd", []byte("abc"), ReproducibleModTime)
+	assert.NoError(t, ar.err, "unexpected error")
+
+	expected := "!<arch>\n" +
+		"debian-binary   315532800   0     0     100644  4         `\n2.0\n" +
+		"odd             315532800   0     0     100644  3         `\nabc\n"
+	assert.Equal(t, expected, out.String(), "ar archive does not match")
+}
+
+func TestWriteDeb(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "exe")
+	readmePath := filepath.Join(dir, "README.md")
+	assert.NoError(t, ioutil.WriteFile(exePath, []byte("binary"), 0700))
+	assert.NoError(t, ioutil.WriteFile(readmePath, []byte("readme"), 0600))
+
+	pkg := Package{OS: "linux", Arch: "amd64", Dir: "exe", Archive: "deb", ExePath: exePath,
+		FileList: []string{exePath, readmePath}}
+	opts := ArchiveOptions{Reproducible: true, ModTime: ReproducibleModTime,
+		PackageInfo: map[string]LinuxPackageInfo{"deb": {Version: "1.0.0", Maintainer: "gop"}}}
+	var out bytes.Buffer
+	assert.NoError(t, writeDeb(&out, pkg, opts), "unexpected error")
+
+	names, members := parseTestAr(t, out.Bytes())
+	assert.Equal(t, []string{"debian-binary", "control.tar.gz", "data.tar.gz"}, names,
+		"ar members do not match")
+	assert.Equal(t, "2.0\n", string(members["debian-binary"]), "debian-binary does not match")
+
+	control := readTestTar(t, bytes.NewReader(members["control.tar.gz"]), true)
+	assert.Equal(t, []string{"./control", "./md5sums"}, testTarNames(control),
+		"control entries do not match")
+	assert.Contains(t, control[0].data, "Package: exe\nVersion: 1.0.0\nArchitecture: amd64\n",
+		"control does not match")
+	expectedSums := fmt.Sprintf("%x  usr/bin/exe\n%x  usr/share/doc/exe/README.md\n",
+		md5.Sum([]byte("binary")), md5.Sum([]byte("readme")))
+	assert.Equal(t, expectedSums, control[1].data, "md5sums do not match")
+
+	data := readTestTar(t, bytes.NewReader(members["data.tar.gz"]), true)
+	expected := []testTarEntry{
+		{"./usr/", 0755, ""},
+		{"./usr/bin/", 0755, ""},
+		{"./usr/bin/exe", 0755, "binary"},
+		{"./usr/share/", 0755, ""},
+		{"./usr/share/doc/", 0755, ""},
+		{"./usr/share/doc/exe/", 0755, ""},
+		{"./usr/share/doc/exe/README.md", 0644, "readme"},
+	}
+	assert.Equal(t, expected, data, "data entries do not match")
+}
+
+// parseTestAr reads the members of an ar archive, returning their names in
+// order and their contents
+func parseTestAr(t *testing.T, data []byte) ([]string, map[string][]byte) {
+	assert.Equal(t, "!<arch>\n", string(data[:8]), "ar magic does not match")
+	data = data[8:]
+	names := []string{}
+	members := map[string][]byte{}
+	for len(data) >= 60 {
+		assert.Equal(t, "`\n", string(data[58:60]), "ar header end does not match")
+		name := strings.TrimSpace(string(data[:16]))
+		size, err := strconv.Atoi(strings.TrimSpace(string(data[48:58])))
+		assert.NoError(t, err, "unexpected error")
+		names = append(names, name)
+		members[name] = data[60 : 60+size]
+		// members are padded to an even size
+		data = data[60+size+size%2:]
+	}
+	assert.Empty(t, data, "trailing ar data")
+	return names, members
+}
+
+// testTarEntry is an entry read back from a tarball by readTestTar
+type testTarEntry struct {
+	name string
+	mode int64
+	data string
+}
+
+// readTestTar reads the entries of an optionally gzipped tarball, checking
+// that they are all owned by root
+func readTestTar(t *testing.T, r io.Reader, gzipped bool) []testTarEntry {
+	if gzipped {
+		gz, err := gzip.NewReader(r)
+		assert.NoError(t, err, "unexpected error")
+		r = gz
+	}
+	entries := []testTarEntry{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF || !assert.NoError(t, err, "unexpected error") {
+			break
+		}
+		assert.Equal(t, "root", hdr.Uname, "%s owner does not match", hdr.Name)
+		data, err := ioutil.ReadAll(tr)
+		assert.NoError(t, err, "unexpected error")
+		entries = append(entries, testTarEntry{hdr.Name, hdr.Mode, string(data)})
+	}
+	return entries
+}
+
+// testTarNames returns the names of the entries read by readTestTar
+func testTarNames(entries []testTarEntry) []string {
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.name)
+	}
+	return names
+}
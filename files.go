@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileSpec is an additional file to add to the archives. The path is a
+// template using the same variables as "--output". The file is only added to
+// the packages matching the package patterns, or to every package if there
+// are none.
+type FileSpec struct {
+	Path     string
+	Packages []string
+}
+
+// GetUserFileSpecs generates the list of additional files from the user
+// defined files. Each item is either a path, or a map with a "path" and an
+// optional list of "packages" the file is limited to.
+func GetUserFileSpecs(userFiles interface{}) ([]FileSpec, error) {
+	items := []interface{}{}
+	switch files := userFiles.(type) {
+	case nil:
+	case []interface{}:
+		items = files
+	default:
+		for _, file := range toStringList(files) {
+			items = append(items, file)
+		}
+	}
+
+	specs := []FileSpec{}
+	for _, item := range items {
+		if file, ok := item.(string); ok {
+			for _, path := range splitListItems([]string{file}) {
+				specs = append(specs, FileSpec{Path: path})
+			}
+			continue
+		}
+
+		fields := map[string]interface{}{}
+		switch file := item.(type) {
+		case map[string]interface{}:
+			fields = file
+		case map[interface{}]interface{}:
+			for key, value := range file {
+				fields[fmt.Sprint(key)] = value
+			}
+		default:
+			return nil, errors.Errorf("could not parse file '%v'", item)
+		}
+		path, _ := fields["path"].(string)
+		if path == "" {
+			return nil, errors.Errorf("file '%v' has no path", item)
+		}
+		specs = append(specs, FileSpec{
+			Path:     path,
+			Packages: splitListItems(toStringList(fields["packages"])),
+		})
+	}
+	return specs, nil
+}
+
+// Matches checks if the file should be added to the package. The package
+// patterns use the "os/arch/archive" or "os/arch/variant/archive" syntax of
+// "--packages", where "*"
+// matches any value and missing parts match everything, e.g. "windows" or
+// "linux/arm64". Patterns beginning with "!" leave the file out of any
+// matching packages.
+func (f FileSpec) Matches(pkg Package) bool {
+	matches, negations := splitNegatedItems(f.Packages)
+	if len(matches) > 0 && !matchesAnyPackage(matches, pkg) {
+		return false
+	}
+	return !matchesAnyPackage(negations, pkg)
+}
+
+func matchesAnyPackage(patterns []string, pkg Package) bool {
+	for _, pattern := range patterns {
+		values := []string{pkg.OS, pkg.Arch, CanonicalArchive(pkg.Archive)}
+		parts := strings.Split(strings.ToLower(pattern), "/")
+		if len(parts) == 4 {
+			values = []string{pkg.OS, pkg.Arch, pkg.Variant, CanonicalArchive(pkg.Archive)}
+		}
+		if len(parts) >= 3 {
+			parts[len(parts)-1] = CanonicalArchive(parts[len(parts)-1])
+		}
+		matched := len(parts) <= len(values)
+		for i := 0; matched && i < len(parts); i++ {
+			matched = parts[i] == "*" || parts[i] == strings.ToLower(values[i])
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveFiles expands the additional files of a package. The includes may
+// be file paths, directories or glob patterns, where "**" matches any number
+// of folders. The excludes are patterns that remove any matching files from
+// the list, and are skipped over when adding the contents of directories.
+// The files are returned along with the name each is archived under. Glob
+// matches keep their path below the folder the pattern starts in, and other
+// files are named after their base name. An error listing every include that
+// does not exist, or matches nothing, is returned. Two files that would be
+// archived under the same name are an error as well.
+func ResolveFiles(includes []string, excludes []string) ([]string, map[string]string, error) {
+	files := []string{}
+	missing := []string{}
+	names := map[string]string{}
+	sources := map[string]string{}
+	for _, include := range includes {
+		if include == "" {
+			continue
+		}
+
+		matches := []string{include}
+		root := ""
+		if isGlobPattern(include) {
+			var err error
+			if matches, err = globFiles(include); err != nil {
+				return nil, nil, err
+			}
+			root = globRoot(path.Clean(filepath.ToSlash(include)))
+		} else if _, err := os.Stat(include); err != nil {
+			matches = nil
+		}
+		if len(matches) == 0 {
+			missing = append(missing, include)
+			continue
+		}
+
+		for _, match := range matches {
+			if _, ok := names[match]; ok || IsExcludedFile(excludes, match) {
+				continue
+			}
+			name := filepath.Base(match)
+			if root != "" {
+				rel, err := filepath.Rel(filepath.FromSlash(root), match)
+				if err != nil {
+					return nil, nil, errors.Wrapf(err, "naming %s", match)
+				}
+				name = filepath.ToSlash(rel)
+			}
+			if other, ok := sources[name]; ok {
+				return nil, nil, errors.Errorf("%s and %s would both be archived as %s",
+					other, match, name)
+			}
+			sources[name] = match
+			names[match] = name
+			files = append(files, match)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, nil, errors.Errorf("no files found for %s", strings.Join(missing, ", "))
+	}
+	return files, names, nil
+}
+
+// FileResolver resolves the additional files of packages, expanding each
+// distinct list of files only once
+type FileResolver struct {
+	results map[string]fileResult
+}
+
+type fileResult struct {
+	files []string
+	names map[string]string
+	err   error
+}
+
+// NewFileResolver creates an empty file resolver
+func NewFileResolver() *FileResolver {
+	return &FileResolver{results: map[string]fileResult{}}
+}
+
+// Resolve replaces the additional files in the file list of the package with
+// the files they expand to
+func (r *FileResolver) Resolve(pkg Package) (Package, error) {
+	if len(pkg.FileList) < 2 {
+		return pkg, nil
+	}
+	includes := pkg.FileList[1:]
+	key := strings.Join(includes, "\x00") + "\x01" + strings.Join(pkg.Excludes, "\x00")
+	result, ok := r.results[key]
+	if !ok {
+		result.files, result.names, result.err = ResolveFiles(includes, pkg.Excludes)
+		r.results[key] = result
+	}
+	if result.err != nil {
+		return pkg, result.err
+	}
+	pkg.FileList = append([]string{pkg.FileList[0]}, result.files...)
+	pkg.FileNames = result.names
+	return pkg, nil
+}
+
+// IsExcludedFile checks if the file path or its base name match any of the
+// exclude patterns
+func IsExcludedFile(excludes []string, filePath string) bool {
+	for _, exclude := range excludes {
+		if MatchFilePattern(exclude, filePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchFilePattern checks if the glob pattern matches the file path or its
+// base name. A "**" in the pattern matches any number of folders.
+func MatchFilePattern(pattern string, filePath string) bool {
+	filePath = path.Clean(filepath.ToSlash(filePath))
+	pattern = path.Clean(filepath.ToSlash(pattern))
+	return matchGlob(pattern, filePath) || matchGlob(pattern, path.Base(filePath))
+}
+
+// globFiles returns the files and directories matching the pattern. The
+// contents of a matching directory are not listed separately.
+func globFiles(pattern string) ([]string, error) {
+	pattern = path.Clean(filepath.ToSlash(pattern))
+	root := globRoot(pattern)
+
+	matches := []string{}
+	err := filepath.Walk(filepath.FromSlash(root), func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return errors.Wrapf(err, "traversing %s", fpath)
+		}
+		if !matchGlob(pattern, path.Clean(filepath.ToSlash(fpath))) {
+			return nil
+		}
+		matches = append(matches, fpath)
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// globRoot is the folder of the pattern that comes before any wildcards
+func globRoot(pattern string) string {
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if isGlobPattern(part) {
+			if i == 0 {
+				return "."
+			}
+			if root := strings.Join(parts[:i], "/"); root != "" {
+				return root
+			}
+			return "/"
+		}
+	}
+	return pattern
+}
+
+func isGlobPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// matchGlob matches the slash separated name against the pattern one folder
+// at a time, letting "**" stand in for any number of folders
+func matchGlob(pattern string, name string) bool {
+	return matchGlobParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobParts(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlobParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// toStringList converts a config value holding a single string or a list of
+// strings to a list of strings
+func toStringList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		list := []string{}
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+		return list
+	}
+	return []string{}
+}
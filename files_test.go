@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	for _, file := range []string{"README.md", "docs/a.md", "docs/api/b.md",
+		"docs/api/c.txt", "docs/old.md", "completions/gop.bash"} {
+		filePath := filepath.Join(dir, file)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0755))
+		assert.NoError(t, ioutil.WriteFile(filePath, []byte(file), 0644))
+	}
+
+	files, names, err := ResolveFiles([]string{
+		filepath.Join(dir, "README.md"),
+		filepath.Join(dir, "docs/**/*.md"),
+		filepath.Join(dir, "completions"),
+	}, []string{"old.md"})
+	assert.NoError(t, err, "unexpected error")
+	expected := []string{
+		filepath.Join(dir, "README.md"),
+		filepath.Join(dir, "docs/a.md"),
+		filepath.Join(dir, "docs/api/b.md"),
+		filepath.Join(dir, "completions"),
+	}
+	assert.Equal(t, expected, files, "files do not match")
+	expectedNames := map[string]string{
+		filepath.Join(dir, "README.md"):     "README.md",
+		filepath.Join(dir, "docs/a.md"):     "a.md",
+		filepath.Join(dir, "docs/api/b.md"): "api/b.md",
+		filepath.Join(dir, "completions"):   "completions",
+	}
+	assert.Equal(t, expectedNames, names, "file names do not match")
+}
+
+func TestResolveFiles_Missing(t *testing.T) {
+	_, _, err := ResolveFiles([]string{"missing.txt", "missing/**/*.md"}, nil)
+	assert.EqualError(t, err, "no files found for missing.txt, missing/**/*.md")
+}
+
+func TestResolveFiles_SameName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	for _, file := range []string{"a/LICENSE", "b/LICENSE"} {
+		filePath := filepath.Join(dir, file)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0755))
+		assert.NoError(t, ioutil.WriteFile(filePath, []byte(file), 0644))
+	}
+
+	// glob matches keep their folders, so only the same path is a clash
+	files, names, err := ResolveFiles([]string{filepath.Join(dir, "*/LICENSE")}, nil)
+	assert.NoError(t, err, "unexpected error")
+	assert.Len(t, files, 2, "incorrect number of files")
+	assert.Equal(t, "a/LICENSE", names[filepath.Join(dir, "a/LICENSE")], "file name does not match")
+	assert.Equal(t, "b/LICENSE", names[filepath.Join(dir, "b/LICENSE")], "file name does not match")
+
+	_, _, err = ResolveFiles([]string{filepath.Join(dir, "a/LICENSE"), filepath.Join(dir, "b/LICENSE")}, nil)
+	assert.Error(t, err, "expected an error")
+	_, _, err = ResolveFiles([]string{filepath.Join(dir, "a/*"), filepath.Join(dir, "b/*")}, nil)
+	assert.Error(t, err, "expected an error")
+}
+
+func TestGetUserFileSpecs(t *testing.T) {
+	specs, err := GetUserFileSpecs([]interface{}{
+		"README.md,LICENSE",
+		map[interface{}]interface{}{"path": "completions/*", "packages": []interface{}{"linux", "!linux/*/deb"}},
+		map[string]interface{}{"path": "install.ps1", "packages": "windows"},
+	})
+	assert.NoError(t, err, "unexpected error")
+	expected := []FileSpec{
+		{Path: "README.md"},
+		{Path: "LICENSE"},
+		{Path: "completions/*", Packages: []string{"linux", "!linux/*/deb"}},
+		{Path: "install.ps1", Packages: []string{"windows"}},
+	}
+	assert.Equal(t, expected, specs, "file specs do not match")
+
+	_, err = GetUserFileSpecs([]interface{}{map[string]interface{}{"packages": "linux"}})
+	assert.Error(t, err, "expected an error")
+}
+
+func TestFileSpecMatches(t *testing.T) {
+	pkg := Package{OS: "linux", Arch: "arm64", Archive: "tgz"}
+	assert.True(t, FileSpec{}.Matches(pkg), "expected a match")
+	assert.True(t, FileSpec{Packages: []string{"linux"}}.Matches(pkg), "expected a match")
+	assert.True(t, FileSpec{Packages: []string{"*/arm64/tar.gz"}}.Matches(pkg), "expected a match")
+	assert.True(t, FileSpec{Packages: []string{"!windows"}}.Matches(pkg), "expected a match")
+	assert.False(t, FileSpec{Packages: []string{"darwin", "windows"}}.Matches(pkg), "unexpected match")
+	assert.False(t, FileSpec{Packages: []string{"linux", "!*/arm64"}}.Matches(pkg), "unexpected match")
+	assert.False(t, FileSpec{Packages: []string{"linux/arm64/zip"}}.Matches(pkg), "unexpected match")
+
+	pkg = Package{OS: "linux", Arch: "arm", Variant: "v7", Archive: "zip"}
+	assert.True(t, FileSpec{Packages: []string{"linux/arm/v7/zip"}}.Matches(pkg), "expected a match")
+	assert.True(t, FileSpec{Packages: []string{"linux/arm"}}.Matches(pkg), "expected a match")
+	assert.False(t, FileSpec{Packages: []string{"linux/arm/v6/*"}}.Matches(pkg), "unexpected match")
+}
+
+func TestMatchFilePattern(t *testing.T) {
+	assert.True(t, MatchFilePattern("**/*.md", "docs/api/b.md"), "expected a match")
+	assert.True(t, MatchFilePattern("docs/**/*.md", "docs/a.md"), "expected a match")
+	assert.True(t, MatchFilePattern("*.bak", "completions/gop.bak"), "expected a match")
+	assert.True(t, MatchFilePattern("docs/**", "./docs/api/c.txt"), "expected a match")
+	assert.False(t, MatchFilePattern("docs/*.md", "docs/api/b.md"), "unexpected match")
+	assert.False(t, MatchFilePattern("**/*.md", "docs/api/c.txt"), "unexpected match")
+}
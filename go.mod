@@ -7,6 +7,7 @@ require (
 	github.com/fatih/color v1.7.0 // indirect
 	github.com/gesquive/cli v0.2.0
 	github.com/golang/snappy v0.0.1 // indirect
+	github.com/klauspost/compress v1.11.13
 	github.com/mattn/go-colorable v0.1.2 // indirect
 	github.com/mholt/archiver v3.1.1+incompatible
 	github.com/nwaples/rardecode v1.0.0 // indirect
@@ -15,5 +16,7 @@ require (
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/viper v1.4.0
 	github.com/stretchr/testify v1.2.2
+	github.com/ulikunitz/xz v0.5.6
 	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
+	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
 )
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/gesquive/cli"
 	"github.com/spf13/cobra"
@@ -14,6 +20,14 @@ import (
 var debug bool
 var showVersion bool
 
+const (
+	// ExitPackageFailure is the exit code used when any archive fails to package
+	ExitPackageFailure = 2
+	// ExitMissingExecutable is the exit code used in strict mode when a
+	// requested package has no executable
+	ExitMissingExecutable = 3
+)
+
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "gop [flags] [packages]",
@@ -27,10 +41,102 @@ Input/Output path template:
 
   The input & output path for the binaries/packages is specified with the
   "--input" and "--output" flags respectively. The value is a string that
-  is a Go text template. The default values are "{{.Dir}}_{{.OS}}_{{.Arch}}"
-  and "{{.Dir}}_{{.OS}}_{{.Arch}}.{{.Archive}}". The variables and
+  is a Go text template. The default values are
+  "{{.Dir}}_{{.OS}}_{{.Arch}}{{.Variant}}" and
+  "{{.Dir}}_{{.OS}}_{{.Arch}}{{.Variant}}.{{.Ext}}". The variables and
   their values should be self-explanatory.
 
+  {{.Ext}} is the file extension of the archive, which is the same as
+  {{.Archive}} except for pacman packages, which end in ".pkg.tar.zst".
+
+  Along with {{.Dir}}, {{.OS}}, {{.Arch}}, {{.Variant}}, {{.Archive}} and
+  {{.Ext}}, the templates can use the release info below. Environment
+  variables written as $VAR or ${VAR} outside of the template actions are
+  expanded as well.
+
+    {{.Version}}   the "--version-string" value, or "git describe" output
+    {{.Commit}}    the git commit hash
+    {{.Date}}      the date as YYYY-MM-DD, taken from SOURCE_DATE_EPOCH if set
+    {{.Env.NAME}}  the value of the NAME environment variable, empty if unset
+
+  The templates can also use the functions below. The value being changed is
+  the last argument, so they work in pipelines such as
+  {{.Arch | replace "amd64" "x86_64"}}.
+
+    lower             lowercase the value, {{lower .OS}}
+    upper             uppercase the value, {{upper .Arch}}
+    title             capitalize each word of the value, {{title .OS}}
+    replace OLD NEW   replace every OLD in the value with NEW
+    trimPrefix PRE    remove PRE from the start of the value,
+                      {{.Version | trimPrefix "v"}}
+    default DEF       use DEF when the value is empty,
+                      {{.Version | default "dev"}}
+    env NAME          the value of the NAME environment variable
+    exe OS            ".exe" on windows and nothing elsewhere, {{exe .OS}}
+
+  The OS & Arch names given to the output template can be renamed with the
+  "replacements" map of the config file, while the input template always
+  uses the Go names:
+
+    replacements:
+      darwin: macOS
+      amd64: x86_64
+      386: i386
+
+  The files inside of an archive can be placed under a top level folder with
+  the "--archive-root" flag. The value is a template using the same variables
+  as "--output", for example "{{.Dir}}_{{.OS}}_{{.Arch}}". By default, files
+  are placed at the root of the archive.
+
+  The name of the executable inside of the archive is given by the
+  "--binary-name" template, which also uses the same variables as "--output".
+  The default value is "{{.Dir}}". Windows executables are always given an
+  ".exe" extension.
+
+Files:
+
+  Additional files are added to every archive with the "--files" flag. Each
+  value may be a file, a directory or a glob pattern such as "docs/*.md",
+  where "**" matches any number of folders, e.g. "docs/**/*.md". Files are
+  placed at the root of the archive under their base name, and directories
+  are added along with all of their contents. Glob matches keep their path
+  below the folder the pattern starts in, so "docs/**/*.md" archives
+  "docs/api/index.md" as "api/index.md". Values beginning with "!" are
+  exclude patterns, e.g. "!*.bak", which are matched against the path and
+  base name of every file, including those inside of directories. File paths
+  are templates using the same variables as "--output", for example
+  "completions/{{.OS}}". The files of each archive are found before packaging
+  begins, and gop exits with an error if a value does not match any files.
+
+  In the config file, a files entry may be limited to some of the archives by
+  giving it a "path" and a list of "packages", which use the "os/arch/archive"
+  syntax of "--packages". A "*" matches any value and left off parts match
+  everything, while patterns beginning with "!" leave the file out:
+
+    files:
+      - LICENSE
+      - path: install.ps1
+        packages: [windows]
+      - path: "man/{{.Dir}}.1"
+        packages: [linux, "!linux/*/zip"]
+
+File modes:
+
+  The executable is given a mode of 0755 inside the archive, and any files
+  added with "--files" are given a mode of 0644. Directories are given 0755.
+  The mode of specific files can be overridden with the "--modes" flag, which
+  is a list of "pattern=mode" values where the mode is in octal. The pattern
+  is matched against the file path and its base name, for example
+  "install.sh=0755" or "docs/*.txt=0600". Later values take precedence.
+
+Reproducible archives:
+
+  With "--reproducible", the entries of each archive are sorted by name, all
+  timestamps are set to 1980-01-01 and the owner info is removed, so that
+  identical inputs always create identical archives. If the SOURCE_DATE_EPOCH
+  environment variable is set, reproducible mode is turned on and its value
+  is used as the timestamp instead.
+
 Packages (OS/Arch/Archive):
 
   The operating systems, architectures, and archives to package may be
@@ -40,17 +146,158 @@ Packages (OS/Arch/Archive):
   If the list is made up of only negations, then the negations will come from
   the default list.
 
+  The default OS and Arch lists are the platforms listed by "go tool dist
+  list", so they follow the installed version of Go. Only the os/arch pairs
+  that Go can build for are packaged. If the go tool can not be run, a built
+  in list is used instead and every pairing of its values is tried.
+
+  The supported archives are zip, tar, tar.gz, tar.bz2, tar.xz, tar.lz4,
+  tar.sz, tar.zst, deb, rpm, apk & pacman. The tar archives may also be
+  given by their short names tgz, tbz2, txz, tlz4, tsz & tzst.
+
   Additionally, the "--packages" flag may be used to specify complete
   os/arch/archive values that should be built or ignored. The syntax for
   this is what you would expect: "linux/amd64/zip" would be a valid package
   value. Multiple values can be space separated. An os/arch/archive definition
   can begin with "!" to not build for that platform.
 
+  A package may also name a variant of its architecture, matching the GOARM,
+  GOAMD64 or GOMIPS value it was built with, as in "linux/arm/v7/tar.gz". The
+  variants are v5, v6 & v7 for arm, v1 to v4 for amd64 and hardfloat &
+  softfloat for mips & mipsle. The variant is available to the templates as
+  {{.Variant}}, and is empty for packages without a variant. The default
+  input & output templates add it after the arch, so "linux/arm/v7/zip" is
+  read from "{{.Dir}}_linux_armv7". Packages that would be written to the
+  same archive path are an error.
+
   The "--packages" flag has the highest precedent when determing whether to
   build for a platform. If it is included in the "--packages" list, it will be
   built even if the specific os, arch or archive is negated in  the "--os",
   "--arch" and "--archive" flags respectively.
 
+Linux packages:
+
+  The deb, rpm, apk & pacman archives build Debian, RPM, Alpine and Arch
+  Linux packages, and are only built for linux. The supported architectures
+  are:
+
+    deb      386, amd64, arm, arm64 & ppc64le
+    rpm      386, amd64, arm64 & ppc64le
+    apk      386, amd64, arm, arm64 & ppc64le
+    pacman   amd64 & arm64
+
+  Debian packages of arm v5 & v6 builds are labelled armel, and Alpine
+  packages of arm v6 builds armhf. Alpine packages can not be built for arm
+  v5.
+
+  Arch Linux packages are zstd compressed at the "--zstd-level" compression
+  level. The {{.Ext}} of the default "--output" template gives them the
+  ".pkg.tar.zst" extension pacman expects.
+
+  The package metadata is read from the section of the config file named
+  after the archive, e.g. "deb":
+
+    name          the package name, defaults to the "{{.Dir}}" value
+    version       the package version, required
+    release       the rpm, apk & pacman release number, defaults to 1
+    maintainer    the package maintainer, required for deb
+    summary       the rpm, apk & pacman summary, defaults to the first line of
+                  description
+    description   the first line is the synopsis, the rest the long description
+    license       the rpm, apk & pacman license
+    homepage      the project homepage
+    section       the deb archive section, e.g. "utils"
+    priority      the deb package priority, e.g. "optional"
+    depends       a list of package dependencies, e.g. "glibc >= 2.17"
+    install-path  where the executable is installed, defaults to "/usr/bin"
+    doc-path      where the additional files are installed under a folder
+                  named after the package, defaults to "/usr/share/doc"
+
+Compression:
+
+  The compression level of each archive format can be set in the
+  "compression" section of the config file, or with the "--compression-level"
+  flag as a list of "archive=level" values. A level given without an archive
+  is used for every archive that supports it. Levels may be a number, or one
+  of "fastest", "best" or "store". The supported levels are:
+
+    zip       1-9, or 0 to store without compression
+    tar.gz    1-9, or 0 to store without compression
+    tar.bz2   1-9
+    tar.xz    0-9
+    tar.lz4   0-16
+
+  The tar.zst level is set with "--zstd-level", from 1 to 22 following the
+  levels used by the zstd command. The tar & tar.sz archives do not support
+  compression levels.
+
+Checksums:
+
+  A checksum file can be generated for the packaged archives by specifying
+  the hashing algorithm with the "--checksum" flag. Supported algorithms are
+  sha256, sha512, sha1, md5 & blake2b. The path of the checksum file is given
+  by the "--checksum-output" template, which uses the same variables as
+  "--output". The default value is "{{.Dir}}_checksums.txt".
+
+Manifest:
+
+  A JSON manifest describing every packaged archive can be written with the
+  "--manifest" flag. Each package is listed with its os, arch, archive type,
+  paths, file list, size, modification time and checksum. The checksum uses
+  the "--checksum" algorithm, or sha256 if none was given. The gop version
+  and the configuration used are included as well.
+
+Homebrew:
+
+  A Homebrew formula for the darwin & linux archives can be written with the
+  "--homebrew" flag. The first archive of each platform is used, skipping any
+  linux packages, and its sha256 is calculated from the packaged archive. The
+  formula metadata is read from the "formula" section of the config file:
+
+    name          the formula name, defaults to the "{{.Dir}}" value
+    description   the formula description
+    homepage      the project homepage
+    license       the project license
+    version       the formula version, defaults to {{.Version}}
+    url           the download url template, required. It uses the same
+                  variables as "--output", along with {{.ArchiveName}}, the
+                  file name of the archive, and {{.Version}}
+
+Scoop:
+
+  A Scoop manifest for the windows/386 & windows/amd64 zip archives can be
+  written with the "--scoop" flag. The sha256 of each archive is calculated
+  from the packaged archive. The manifest metadata is read from the
+  "scoop-manifest" section of the config file:
+
+    description   the app description
+    homepage      the project homepage
+    license       the project license
+    version       the app version, defaults to {{.Version}}
+    url           the download url template, required. It uses the same
+                  variables as the "formula" url template
+
+Failures:
+
+  If any archive fails to package, a summary of the failures is printed once
+  packaging is done and gop exits with a status of 2. The executables are not
+  deleted by "--delete" when a failure occurs. Use "--keep-going=false" to stop
+  packaging at the first failure.
+
+  Packages without an executable are skipped. A package is considered to be
+  requested if it is listed in "--packages", or if both its OS and arch were
+  explicitly given with "--os" and "--arch". The packaged and skipped packages
+  are listed at the end of the run, with a warning for each requested package
+  that has no executable. With "--strict", gop refuses to package anything
+  and exits with a status of 3 instead.
+
+Dry run:
+
+  Use "--dry-run" to print the packaging plan without writing any archives or
+  deleting any executables. Each package is listed with its resolved input
+  and output paths, the files that would be archived and whether the
+  executable exists.
+
 `,
 	PersistentPreRun: preRun,
 	Run:              run,
@@ -76,54 +323,127 @@ func init() {
 	RootCmd.PersistentFlags().BoolVarP(&showVersion, "version", "V", false,
 		"Show the version and exit")
 
-	RootCmd.PersistentFlags().StringP("input", "i", "{{.Dir}}_{{.OS}}_{{.Arch}}",
+	RootCmd.PersistentFlags().StringP("input", "i", "{{.Dir}}_{{.OS}}_{{.Arch}}{{.Variant}}",
 		"The input path template.")
-	RootCmd.PersistentFlags().StringP("output", "o", "{{.Dir}}_{{.OS}}_{{.Arch}}.{{.Archive}}",
+	RootCmd.PersistentFlags().StringP("output", "o", "{{.Dir}}_{{.OS}}_{{.Arch}}{{.Variant}}.{{.Ext}}",
 		"The output path template.")
 
+	RootCmd.PersistentFlags().StringP("archive-root", "R", "",
+		"The template of the folder to place files under inside the archive.")
+	RootCmd.PersistentFlags().StringP("binary-name", "b", "{{.Dir}}",
+		"The template of the executable name inside the archive.")
+
+	RootCmd.PersistentFlags().String("version-string", "",
+		"The version given to the templates (default from git describe)")
+
 	RootCmd.PersistentFlags().StringSliceP("files", "f", []string{},
-		"Add additional file to package")
+		"Add additional files, directories or globs to package")
+	RootCmd.PersistentFlags().StringSlice("modes", []string{},
+		"List of file=mode overrides for files inside the archive")
+	RootCmd.PersistentFlags().Bool("reproducible", false,
+		"Create archives that are identical for identical inputs")
 	RootCmd.PersistentFlags().StringSliceP("archive", "r", DefaultArchiveList,
 		"List of package types to create")
-	RootCmd.PersistentFlags().StringSliceP("os", "s", OSList,
-		"List of operating systems to package")
-	RootCmd.PersistentFlags().StringSliceP("arch", "a", ArchList,
-		"List of architectures to package")
+	RootCmd.PersistentFlags().StringSliceP("compression-level", "l", []string{},
+		"List of archive=level compression levels")
+	RootCmd.PersistentFlags().Int("zstd-level", DefaultZstdLevel,
+		"The compression level of tar.zst archives")
+	RootCmd.PersistentFlags().StringSliceP("os", "s", []string{},
+		"List of operating systems to package (default all go supports)")
+	RootCmd.PersistentFlags().StringSliceP("arch", "a", []string{},
+		"List of architectures to package (default all go supports)")
 	RootCmd.PersistentFlags().StringSliceP("packages", "p", []string{},
 		"List of os/arch/archive groups to package")
 	RootCmd.PersistentFlags().BoolP("delete", "d", false,
 		"Delete the packaged executables")
+	RootCmd.PersistentFlags().String("checksum", "",
+		"Generate a checksum file using this algorithm")
+	RootCmd.PersistentFlags().String("checksum-output", "{{.Dir}}_checksums.txt",
+		"The checksum file path template.")
+	RootCmd.PersistentFlags().StringP("manifest", "m", "",
+		"Write a JSON manifest of the packaged archives to this path")
+	RootCmd.PersistentFlags().String("homebrew", "",
+		"Write a Homebrew formula for the darwin & linux archives to this path")
+	RootCmd.PersistentFlags().String("scoop", "",
+		"Write a Scoop manifest for the windows zip archives to this path")
+	RootCmd.PersistentFlags().IntP("parallel", "j", 0,
+		"Number of archives to package at once (default GOMAXPROCS)")
+	RootCmd.PersistentFlags().BoolP("keep-going", "k", true,
+		"Keep packaging after an archive fails")
+	RootCmd.PersistentFlags().Bool("strict", false,
+		"Fail if a requested package has no executable")
+	RootCmd.PersistentFlags().BoolP("dry-run", "n", false,
+		"Print the packages that would be created and exit")
 
 	RootCmd.PersistentFlags().MarkHidden("debug")
 
 	viper.SetEnvPrefix("gop")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 	viper.BindEnv("config")
 	viper.BindEnv("input")
 	viper.BindEnv("output")
+	viper.BindEnv("archive-root")
+	viper.BindEnv("binary-name")
+	viper.BindEnv("version-string")
 	viper.BindEnv("files")
+	viper.BindEnv("modes")
+	viper.BindEnv("reproducible")
 	viper.BindEnv("archive")
+	viper.BindEnv("compression-level")
+	viper.BindEnv("zstd-level")
 	viper.BindEnv("os")
 	viper.BindEnv("arch")
 	viper.BindEnv("packages")
 	viper.BindEnv("delete")
+	viper.BindEnv("checksum")
+	viper.BindEnv("checksum-output")
+	viper.BindEnv("manifest")
+	viper.BindEnv("homebrew")
+	viper.BindEnv("scoop")
+	viper.BindEnv("parallel")
+	viper.BindEnv("keep-going")
+	viper.BindEnv("strict")
+	viper.BindEnv("dry-run")
 
 	viper.BindPFlag("config", RootCmd.PersistentFlags().Lookup("config"))
 	viper.BindPFlag("input", RootCmd.PersistentFlags().Lookup("input"))
 	viper.BindPFlag("output", RootCmd.PersistentFlags().Lookup("output"))
+	viper.BindPFlag("archive-root", RootCmd.PersistentFlags().Lookup("archive-root"))
+	viper.BindPFlag("binary-name", RootCmd.PersistentFlags().Lookup("binary-name"))
+	viper.BindPFlag("version-string", RootCmd.PersistentFlags().Lookup("version-string"))
 	viper.BindPFlag("files", RootCmd.PersistentFlags().Lookup("files"))
+	viper.BindPFlag("modes", RootCmd.PersistentFlags().Lookup("modes"))
+	viper.BindPFlag("reproducible", RootCmd.PersistentFlags().Lookup("reproducible"))
 	viper.BindPFlag("archive", RootCmd.PersistentFlags().Lookup("archive"))
+	viper.BindPFlag("compression-level", RootCmd.PersistentFlags().Lookup("compression-level"))
+	viper.BindPFlag("zstd-level", RootCmd.PersistentFlags().Lookup("zstd-level"))
 	viper.BindPFlag("os", RootCmd.PersistentFlags().Lookup("os"))
 	viper.BindPFlag("arch", RootCmd.PersistentFlags().Lookup("arch"))
 	viper.BindPFlag("packages", RootCmd.PersistentFlags().Lookup("packages"))
 	viper.BindPFlag("delete", RootCmd.PersistentFlags().Lookup("delete"))
-
-	viper.SetDefault("input", "{{.Dir}}_{{.OS}}_{{.Arch}}")
-	viper.SetDefault("output", "{{.Dir}}_{{.OS}}_{{.Arch}}.{{.Archive}}")
+	viper.BindPFlag("checksum", RootCmd.PersistentFlags().Lookup("checksum"))
+	viper.BindPFlag("checksum-output", RootCmd.PersistentFlags().Lookup("checksum-output"))
+	viper.BindPFlag("manifest", RootCmd.PersistentFlags().Lookup("manifest"))
+	viper.BindPFlag("homebrew", RootCmd.PersistentFlags().Lookup("homebrew"))
+	viper.BindPFlag("scoop", RootCmd.PersistentFlags().Lookup("scoop"))
+	viper.BindPFlag("parallel", RootCmd.PersistentFlags().Lookup("parallel"))
+	viper.BindPFlag("keep-going", RootCmd.PersistentFlags().Lookup("keep-going"))
+	viper.BindPFlag("strict", RootCmd.PersistentFlags().Lookup("strict"))
+	viper.BindPFlag("dry-run", RootCmd.PersistentFlags().Lookup("dry-run"))
+
+	viper.SetDefault("input", "{{.Dir}}_{{.OS}}_{{.Arch}}{{.Variant}}")
+	viper.SetDefault("output", "{{.Dir}}_{{.OS}}_{{.Arch}}{{.Variant}}.{{.Ext}}")
+	viper.SetDefault("binary-name", "{{.Dir}}")
 	viper.SetDefault("archive", DefaultArchiveList)
-	viper.SetDefault("os", OSList)
-	viper.SetDefault("arch", ArchList)
+	viper.SetDefault("zstd-level", DefaultZstdLevel)
 	viper.SetDefault("delete", false)
+	viper.SetDefault("reproducible", false)
+	viper.SetDefault("checksum-output", "{{.Dir}}_checksums.txt")
+	viper.SetDefault("parallel", 0)
+	viper.SetDefault("keep-going", true)
+	viper.SetDefault("strict", false)
+	viper.SetDefault("dry-run", false)
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -183,8 +503,80 @@ func run(cmd *cobra.Command, args []string) {
 	outputTemplate := viper.GetString("output")
 	cli.Debug("cfg: output=%s", outputTemplate)
 
-	fileList := viper.GetStringSlice("files")
-	cli.Debug("cfg: files=%v", fileList)
+	replacements := viper.GetStringMapString("replacements")
+	cli.Debug("cfg: replacements=%v", replacements)
+
+	rootTemplate := viper.GetString("archive-root")
+	cli.Debug("cfg: archive-root=%s", rootTemplate)
+
+	binaryTemplate := viper.GetString("binary-name")
+	cli.Debug("cfg: binary-name=%s", binaryTemplate)
+
+	// the release date follows SOURCE_DATE_EPOCH so reproducible builds keep
+	// the same archive names, and it is the timestamp of the archive entries
+	releaseDate := time.Now().UTC()
+	epoch, hasEpoch := os.LookupEnv("SOURCE_DATE_EPOCH")
+	if hasEpoch {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			cli.Fatal("error parsing SOURCE_DATE_EPOCH: %s", err)
+		}
+		releaseDate = time.Unix(seconds, 0).UTC()
+	}
+	buildInfo := GetBuildInfo(viper.GetString("version-string"), releaseDate)
+	cli.Debug("cfg: version=%s commit=%s date=%s", buildInfo.Version, buildInfo.Commit, buildInfo.Date)
+
+	fileSpecs, err := GetUserFileSpecs(viper.Get("files"))
+	if err != nil {
+		cli.Fatal("error getting files: %s", err)
+	}
+	cli.Debug("cfg: files=%v", fileSpecs)
+
+	fileModes, err := GetUserFileModes(viper.GetStringSlice("modes"))
+	if err != nil {
+		cli.Fatal("error getting file modes: %s", err)
+	}
+	cli.Debug("cfg: modes=%v", fileModes)
+	archiveOpts := ArchiveOptions{Modes: fileModes}
+
+	archiveOpts.Reproducible = viper.GetBool("reproducible")
+	archiveOpts.ModTime = ReproducibleModTime
+	if hasEpoch {
+		archiveOpts.Reproducible = true
+		archiveOpts.ModTime = releaseDate
+	}
+	cli.Debug("cfg: reproducible=%t mtime=%s", archiveOpts.Reproducible, archiveOpts.ModTime)
+
+	archiveOpts.CompressionLevels, err = GetUserCompressionLevels(
+		viper.GetStringMapString("compression"), viper.GetStringSlice("compression-level"))
+	if err != nil {
+		cli.Fatal("error getting compression levels: %s", err)
+	}
+	cli.Debug("cfg: compression=%v", archiveOpts.CompressionLevels)
+
+	archiveOpts.ZstdLevel = viper.GetInt("zstd-level")
+	if archiveOpts.ZstdLevel < MinZstdLevel || archiveOpts.ZstdLevel > MaxZstdLevel {
+		cli.Fatal("error getting zstd level: must be between %d and %d",
+			MinZstdLevel, MaxZstdLevel)
+	}
+	cli.Debug("cfg: zstd-level=%d", archiveOpts.ZstdLevel)
+
+	archiveOpts.PackageInfo = map[string]LinuxPackageInfo{}
+	for format := range LinuxPackageFormats {
+		var info LinuxPackageInfo
+		if err := viper.UnmarshalKey(format, &info); err != nil {
+			cli.Fatal("error reading %s package info: %s", format, err)
+		}
+		archiveOpts.PackageInfo[format] = info
+		cli.Debug("cfg: %s=%+v", format, info)
+	}
+
+	// the os & arch defaults follow the platforms the installed go supports
+	if err := LoadPlatforms("go"); err != nil {
+		cli.Debug("using the built in os/arch lists: %s", err)
+	} else {
+		cli.Debug("platforms: %d valid os/arch pairs", len(ValidPlatforms))
+	}
 
 	archList := viper.GetStringSlice("arch")
 	cli.Debug("cfg: arch=%v", archList)
@@ -195,6 +587,63 @@ func run(cmd *cobra.Command, args []string) {
 	archiveList := viper.GetStringSlice("archive")
 	cli.Debug("cfg: archive=%v", archiveList)
 
+	checksumAlgorithm := viper.GetString("checksum")
+	cli.Debug("cfg: checksum=%s", checksumAlgorithm)
+	if checksumAlgorithm != "" {
+		if _, err := NewChecksumHash(checksumAlgorithm); err != nil {
+			cli.Fatal("error: %s", err)
+		}
+	}
+
+	checksumTemplate := viper.GetString("checksum-output")
+	cli.Debug("cfg: checksum-output=%s", checksumTemplate)
+
+	manifestPath := viper.GetString("manifest")
+	cli.Debug("cfg: manifest=%s", manifestPath)
+
+	formulaPath := viper.GetString("homebrew")
+	cli.Debug("cfg: homebrew=%s", formulaPath)
+	var formulaInfo FormulaInfo
+	if err := viper.UnmarshalKey("formula", &formulaInfo); err != nil {
+		cli.Fatal("error reading formula info: %s", err)
+	}
+	if formulaInfo.Version == "" {
+		formulaInfo.Version = buildInfo.Version
+	}
+	cli.Debug("cfg: formula=%+v", formulaInfo)
+
+	scoopPath := viper.GetString("scoop")
+	cli.Debug("cfg: scoop=%s", scoopPath)
+	var scoopInfo ScoopInfo
+	if err := viper.UnmarshalKey("scoop-manifest", &scoopInfo); err != nil {
+		cli.Fatal("error reading scoop manifest info: %s", err)
+	}
+	if scoopInfo.Version == "" {
+		scoopInfo.Version = buildInfo.Version
+	}
+	cli.Debug("cfg: scoop-manifest=%+v", scoopInfo)
+
+	// the manifest always carries checksums, even without a checksum file
+	checksumFile := checksumAlgorithm != ""
+	if manifestPath != "" && !checksumFile {
+		checksumAlgorithm = "sha256"
+	}
+
+	dryRun := viper.GetBool("dry-run")
+	cli.Debug("cfg: dry-run=%t", dryRun)
+
+	strict := viper.GetBool("strict")
+	cli.Debug("cfg: strict=%t", strict)
+
+	keepGoing := viper.GetBool("keep-going")
+	cli.Debug("cfg: keep-going=%t", keepGoing)
+
+	parallel := viper.GetInt("parallel")
+	if parallel < 1 {
+		parallel = runtime.GOMAXPROCS(0)
+	}
+	cli.Debug("cfg: parallel=%d", parallel)
+
 	// Get the packages that are in the given paths
 	appDirs, err := GetAppDirs(srcPackages)
 	if err != nil {
@@ -207,33 +656,163 @@ func run(cmd *cobra.Command, args []string) {
 		cli.Fatal("error getting package list: %s", err)
 	}
 	cli.Debug("packages found: %s", packages)
+	for i := range packages {
+		packages[i].BuildInfo = buildInfo
+	}
 
-	packages, err = GetPackagePaths(packages, appDirs, inputTemplate, outputTemplate)
+	packages, err = GetPackagePaths(packages, appDirs, inputTemplate, outputTemplate,
+		replacements)
 	if err != nil {
 		cli.Fatal("error getting package paths: %s", err)
 	}
 
-	packages, err = GetPackageFiles(packages, fileList)
+	packages, err = GetPackageRoots(packages, rootTemplate)
+	if err != nil {
+		cli.Fatal("error getting archive roots: %s", err)
+	}
+
+	packages, err = GetPackageBinaryNames(packages, binaryTemplate)
+	if err != nil {
+		cli.Fatal("error getting binary names: %s", err)
+	}
+
+	packages, err = GetPackageFiles(packages, fileSpecs)
 	if err != nil {
 		cli.Fatal("error getting package files: %s", err)
 	}
 
-	cli.Info("Packaging archives:")
+	// only lists the user actually gave count towards a request
+	var requestedArchs, requestedOSs []string
+	if isUserSet(cmd, "arch") {
+		requestedArchs = archList
+	}
+	if isUserSet(cmd, "os") {
+		requestedOSs = osList
+	}
 
-	for _, pkg := range packages {
+	found := []Package{}
+	skipped := []Package{}
+	missing := []Package{}
+	// files are only looked for in the packages that will be archived
+	resolver := NewFileResolver()
+	fileErrors := []string{}
+	for i, pkg := range packages {
 		if _, err := os.Stat(pkg.ExePath); os.IsNotExist(err) {
-			cli.Debug("xxx %60s", pkg.ArchivePath)
+			skipped = append(skipped, pkg)
+			if IsRequestedPackage(pkg, requestedArchs, requestedOSs, userPackages) {
+				missing = append(missing, pkg)
+			}
+			continue
+		}
+		if packages[i], err = resolver.Resolve(pkg); err != nil {
+			if !containsItem(fileErrors, err.Error()) {
+				fileErrors = append(fileErrors, err.Error())
+			}
 			continue
 		}
+		found = append(found, packages[i])
+	}
+	if len(fileErrors) > 0 {
+		for _, fileError := range fileErrors {
+			cli.Error("error getting files: %s", fileError)
+		}
+		os.Exit(1)
+	}
+
+	// archives sharing a path would be written over each other by the workers
+	if err := CheckArchivePaths(found); err != nil {
+		cli.Fatal("error getting package paths: %s", err)
+	}
+
+	if dryRun {
+		printPackagePlan(os.Stdout, packages)
+		return
+	}
+
+	if strict && len(missing) > 0 {
+		cli.Error("Missing executables for %d requested packages:", len(missing))
+		for _, pkg := range missing {
+			cli.Error("  %s: %s", pkg.String(), pkg.ExePath)
+		}
+		os.Exit(ExitMissingExecutable)
+	}
+
+	cli.Info("Packaging archives:")
+
+	packaged := []Package{}
+	failed := []Package{}
+	failures := []error{}
+	attempted := PackageParallel(found, parallel, keepGoing, func(pkg *Package) error {
+		return packageArchive(pkg, archiveOpts, checksumAlgorithm)
+	}, func(pkg Package, err error) {
+		if err != nil {
+			cli.Info("!!! %60s", pkg.ArchivePath)
+			failed = append(failed, pkg)
+			failures = append(failures, err)
+			return
+		}
 		cli.Info("--> %60s", pkg.ArchivePath)
-		err = archive(pkg.ArchivePath, pkg.Archive, pkg.FileList)
+		packaged = append(packaged, pkg)
+	})
+
+	writeFailed := false
+	if checksumFile && len(packaged) > 0 {
+		checksumPaths, checksumGroups, err := GetChecksumFiles(packaged, checksumTemplate)
+		if err != nil {
+			cli.Fatal("error getting checksum paths: %s", err)
+		}
+		cli.Info("Writing checksums:")
+		for _, checksumPath := range checksumPaths {
+			cli.Info("--> %60s", checksumPath)
+			if err := WriteChecksumFile(checksumPath, checksumGroups[checksumPath]); err != nil {
+				cli.Error("error: %s", err)
+				writeFailed = true
+			}
+		}
+	}
+
+	if manifestPath != "" {
+		cli.Info("Writing manifest:")
+		cli.Info("--> %60s", manifestPath)
+		manifest, err := NewManifest(packaged, checksumAlgorithm, viper.AllSettings())
+		if err == nil {
+			err = WriteManifest(manifestPath, manifest)
+		}
+		if err != nil {
+			cli.Error("error: %s", err)
+			writeFailed = true
+		}
+	}
+
+	if formulaPath != "" {
+		cli.Info("Writing formula:")
+		cli.Info("--> %60s", formulaPath)
+		formula, err := NewFormula(packaged, formulaInfo)
+		if err == nil {
+			err = WriteFormula(formulaPath, formula)
+		}
+		if err != nil {
+			cli.Error("error: %s", err)
+			writeFailed = true
+		}
+	}
+
+	if scoopPath != "" {
+		cli.Info("Writing scoop manifest:")
+		cli.Info("--> %60s", scoopPath)
+		manifest, err := NewScoopManifest(packaged, scoopInfo)
+		if err == nil {
+			err = WriteScoopManifest(scoopPath, manifest)
+		}
 		if err != nil {
 			cli.Error("error: %s", err)
+			writeFailed = true
 		}
 	}
 
+	// keep the executables around if anything failed so they can be repackaged
 	cli.Debug("cfg: delete=%t", viper.GetBool("delete"))
-	if viper.GetBool("delete") {
+	if viper.GetBool("delete") && len(failed) == 0 && !writeFailed {
 		cli.Info("Cleaning up executables")
 		for _, pkg := range packages {
 			os.Remove(pkg.ExePath)
@@ -243,4 +822,104 @@ func run(cmd *cobra.Command, args []string) {
 			}
 		}
 	}
+
+	cli.Info("Packaged %d archives, skipped %d with no executable",
+		len(packaged), len(skipped))
+	for _, pkg := range packaged {
+		cli.Info("  packaged %s: %s", pkg.String(), pkg.ArchivePath)
+	}
+	for _, line := range skippedPackageLines(skipped, missing) {
+		cli.Info("  skipped %s", line)
+	}
+	for _, pkg := range missing {
+		cli.Warn("  missing requested %s: %s", pkg.String(), pkg.ExePath)
+	}
+
+	if len(failed) > 0 {
+		cli.Error("Packaged %d of %d archives, %d failed:", len(packaged), len(found), len(failed))
+		for i, pkg := range failed {
+			cli.Error("  %s: %s", pkg.ArchivePath, failures[i])
+		}
+		if attempted < len(found) {
+			cli.Error("Aborted after the first failure, %d archives were not packaged",
+				len(found)-attempted)
+		}
+		os.Exit(ExitPackageFailure)
+	}
+	if writeFailed {
+		os.Exit(ExitPackageFailure)
+	}
+}
+
+// printPackagePlan writes a table describing how each package would be
+// packaged
+func printPackagePlan(out io.Writer, packages []Package) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OS\tARCH\tARCHIVE\tEXISTS\tINPUT\tOUTPUT\tROOT\tFILES")
+	for _, pkg := range packages {
+		_, err := os.Stat(pkg.ExePath)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\t%s\t%s\n", pkg.OS, pkg.Platform(), pkg.Archive,
+			err == nil, pkg.ExePath, pkg.ArchivePath, pkg.ArchiveRoot,
+			strings.Join(pkg.FileList, ","))
+	}
+	w.Flush()
+}
+
+// skippedPackageLines groups the skipped packages by their missing
+// executable. The requested packages are left out, as they are listed on
+// their own.
+func skippedPackageLines(skipped []Package, missing []Package) []string {
+	requested := []string{}
+	for _, pkg := range missing {
+		requested = append(requested, pkg.String())
+	}
+
+	exePaths := []string{}
+	names := map[string][]string{}
+	for _, pkg := range skipped {
+		if containsItem(requested, pkg.String()) {
+			continue
+		}
+		if _, ok := names[pkg.ExePath]; !ok {
+			exePaths = append(exePaths, pkg.ExePath)
+		}
+		names[pkg.ExePath] = append(names[pkg.ExePath], pkg.String())
+	}
+
+	lines := []string{}
+	for _, exePath := range exePaths {
+		lines = append(lines, fmt.Sprintf("%s: %s", exePath, strings.Join(names[exePath], ", ")))
+	}
+	return lines
+}
+
+// isUserSet checks if the user gave a value for the key, rather than relying
+// on the default value
+func isUserSet(cmd *cobra.Command, key string) bool {
+	if flag := cmd.Flag(key); flag != nil && flag.Changed {
+		return true
+	}
+	envKey := "GOP_" + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+	if _, ok := os.LookupEnv(envKey); ok {
+		return true
+	}
+	return viper.InConfig(key)
+}
+
+// packageArchive creates the archive for the package, recording the
+// checksum of the archive when a checksum algorithm is given
+func packageArchive(pkg *Package, opts ArchiveOptions, checksumAlgorithm string) error {
+	if checksumAlgorithm == "" {
+		return archive(*pkg, opts)
+	}
+
+	hash, err := NewChecksumHash(checksumAlgorithm)
+	if err != nil {
+		return err
+	}
+	if err := archive(*pkg, opts, hash); err != nil {
+		return err
+	}
+	pkg.Checksum = hex.EncodeToString(hash.Sum(nil))
+	return nil
 }
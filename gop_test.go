@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintPackagePlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "exe_linux_arm")
+	assert.NoError(t, ioutil.WriteFile(exePath, []byte("exe"), 0755))
+
+	pkgs := []Package{
+		Package{OS: "linux", Arch: "arm", Variant: "v7", Archive: "tar.gz", ExePath: exePath,
+			ArchivePath: "exe_linux_armv7.tar.gz", ArchiveRoot: "exe",
+			FileList: []string{exePath, "README.md"}},
+		Package{OS: "windows", Arch: "amd64", Archive: "zip", ExePath: "missing.exe",
+			ArchivePath: "exe_windows_amd64.zip", FileList: []string{"missing.exe"}},
+	}
+
+	var out bytes.Buffer
+	printPackagePlan(&out, pkgs)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3, "incorrect number of lines")
+
+	assert.Equal(t, []string{"OS", "ARCH", "ARCHIVE", "EXISTS", "INPUT", "OUTPUT", "ROOT", "FILES"},
+		strings.Fields(lines[0]), "header does not match")
+	assert.Equal(t, []string{"linux", "arm/v7", "tar.gz", "true", exePath,
+		"exe_linux_armv7.tar.gz", "exe", exePath + ",README.md"},
+		strings.Fields(lines[1]), "first package does not match")
+	assert.Equal(t, []string{"windows", "amd64", "zip", "false", "missing.exe",
+		"exe_windows_amd64.zip", "missing.exe"},
+		strings.Fields(lines[2]), "second package does not match")
+}
+
+func TestSkippedPackageLines(t *testing.T) {
+	skipped := []Package{
+		Package{OS: "linux", Arch: "arm", Archive: "zip", ExePath: "exe_linux_arm"},
+		Package{OS: "darwin", Arch: "amd64", Archive: "zip", ExePath: "exe_darwin_amd64"},
+		Package{OS: "linux", Arch: "arm", Archive: "tar.gz", ExePath: "exe_linux_arm"},
+		Package{OS: "darwin", Arch: "amd64", Archive: "tar.gz", ExePath: "exe_darwin_amd64"},
+	}
+	missing := []Package{skipped[1]}
+
+	expected := []string{
+		"exe_linux_arm: linux/arm/zip, linux/arm/tar.gz",
+		"exe_darwin_amd64: darwin/amd64/tar.gz",
+	}
+	assert.Equal(t, expected, skippedPackageLines(skipped, missing), "skipped lines do not match")
+	assert.Empty(t, skippedPackageLines([]Package{}, missing), "expected no skipped lines")
+}
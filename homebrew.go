@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// FormulaInfo is the metadata of a Homebrew formula, set in the "formula"
+// section of the config file
+type FormulaInfo struct {
+	Name        string `mapstructure:"name"`
+	Description string `mapstructure:"description"`
+	Homepage    string `mapstructure:"homepage"`
+	License     string `mapstructure:"license"`
+	Version     string `mapstructure:"version"`
+	URL         string `mapstructure:"url"`
+}
+
+// FormulaArchs maps the Go architectures to the Homebrew CPU checks
+var FormulaArchs = map[string]string{
+	"amd64": "Hardware::CPU.intel? && Hardware::CPU.is_64_bit?",
+	"386":   "Hardware::CPU.intel? && Hardware::CPU.is_32_bit?",
+	"arm64": "Hardware::CPU.arm? && Hardware::CPU.is_64_bit?",
+	"arm":   "Hardware::CPU.arm? && Hardware::CPU.is_32_bit?",
+}
+
+// Formula is a Homebrew formula installing the executable from the archive
+// that matches the platform
+type Formula struct {
+	FormulaInfo
+	ClassName string
+	Binary    string
+	MacOS     []FormulaArchive
+	Linux     []FormulaArchive
+}
+
+// FormulaArchive is an archive referenced by a formula
+type FormulaArchive struct {
+	CPU    string
+	URL    string
+	SHA256 string
+}
+
+var formulaTemplate = template.Must(template.New("formula").Funcs(template.FuncMap{
+	"ruby": rubyQuote,
+}).Parse(`# Generated by gop
+class {{.ClassName}} < Formula
+{{- if .Description}}
+  desc {{ruby .Description}}
+{{- end}}
+{{- if .Homepage}}
+  homepage {{ruby .Homepage}}
+{{- end}}
+{{- if .Version}}
+  version {{ruby .Version}}
+{{- end}}
+{{- if .License}}
+  license {{ruby .License}}
+{{- end}}
+{{- if .MacOS}}
+
+  on_macos do
+{{- range .MacOS}}
+    if {{.CPU}}
+      url {{ruby .URL}}
+      sha256 {{ruby .SHA256}}
+    end
+{{- end}}
+  end
+{{- end}}
+{{- if .Linux}}
+
+  on_linux do
+{{- range .Linux}}
+    if {{.CPU}}
+      url {{ruby .URL}}
+      sha256 {{ruby .SHA256}}
+    end
+{{- end}}
+  end
+{{- end}}
+
+  def install
+    bin.install {{ruby .Binary}}
+  end
+end
+`))
+
+// NewFormula creates a formula referencing the darwin & linux archives of
+// the packages. Only the first archive of each platform is used, and linux
+// packages such as deb & rpm are passed over. The sha256 of each archive is
+// read from its archive path.
+func NewFormula(packages []Package, info FormulaInfo) (Formula, error) {
+	formula := Formula{FormulaInfo: info}
+	if info.URL == "" {
+		return formula, errors.New("formula.url must be set in the config file")
+	}
+	urlTpl, err := newTemplate("url", info.URL)
+	if err != nil {
+		return formula, errors.Wrap(err, "formula url template error")
+	}
+
+	seen := map[string]bool{}
+	for _, pkg := range packages {
+		cpu, ok := FormulaArchs[pkg.Arch]
+		_, isLinuxPackage := LinuxPackageFormats[CanonicalArchive(pkg.Archive)]
+		platform := pkg.OS + "/" + pkg.Arch
+		if !ok || isLinuxPackage || seen[platform] || (pkg.OS != "darwin" && pkg.OS != "linux") {
+			continue
+		}
+		seen[platform] = true
+
+		url, sum, err := getReleaseURL(urlTpl, pkg, info.Version)
+		if err != nil {
+			return formula, err
+		}
+
+		archive := FormulaArchive{CPU: cpu, URL: url, SHA256: sum}
+		if pkg.OS == "darwin" {
+			formula.MacOS = append(formula.MacOS, archive)
+		} else {
+			formula.Linux = append(formula.Linux, archive)
+		}
+		if formula.Name == "" {
+			formula.Name = pkg.Dir
+		}
+		if formula.Binary == "" {
+			formula.Binary = formulaBinary(pkg)
+		}
+	}
+
+	if len(seen) == 0 {
+		return formula, errors.New("no darwin or linux archives to add to the formula")
+	}
+	formula.ClassName = formulaClassName(formula.Name)
+	return formula, nil
+}
+
+// WriteFormula writes the formula to path as Ruby
+func WriteFormula(path string, formula Formula) error {
+	var data bytes.Buffer
+	if err := formulaTemplate.Execute(&data, formula); err != nil {
+		return errors.Wrap(err, "generating formula")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "making formula folder")
+	}
+	if err := ioutil.WriteFile(path, data.Bytes(), 0644); err != nil {
+		return errors.Wrapf(err, "writing %s", path)
+	}
+	return nil
+}
+
+// formulaBinary is the path of the executable once Homebrew has unpacked the
+// archive. Homebrew moves into the top level folder of the archive if there
+// is only one, so the first level of the archive root is left off.
+func formulaBinary(pkg Package) string {
+	binary := pkg.BinaryName
+	if binary == "" {
+		binary = filepath.Base(pkg.ExePath)
+	}
+	root := strings.SplitN(pkg.ArchiveRoot, "/", 2)
+	if len(root) == 2 {
+		return path.Join(root[1], binary)
+	}
+	return binary
+}
+
+// formulaClassName converts a formula name to the Ruby class name Homebrew
+// expects, e.g. "my-app" becomes "MyApp"
+func formulaClassName(name string) string {
+	var className strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	}) {
+		part = strings.Replace(part, "@", "AT", -1)
+		className.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return className.String()
+}
+
+// rubyQuote quotes the value as a Ruby string
+func rubyQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `#`, `\#`).Replace(value) + `"`
+}
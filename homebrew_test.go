@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFormula(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	packages := []Package{}
+	for _, platform := range [][3]string{
+		{"linux", "amd64", "deb"},
+		{"linux", "amd64", "tar.gz"},
+		{"linux", "amd64", "zip"},
+		{"darwin", "arm64", "tar.gz"},
+		{"windows", "amd64", "zip"},
+		{"linux", "ppc64le", "tar.gz"},
+	} {
+		archivePath := filepath.Join(dir, platform[0]+"_"+platform[1]+"."+platform[2])
+		assert.NoError(t, ioutil.WriteFile(archivePath, []byte("archive"), 0644))
+		packages = append(packages, Package{OS: platform[0], Arch: platform[1],
+			Archive: platform[2], ArchivePath: archivePath, Dir: "my-app",
+			ArchiveRoot: "my-app/bin", BinaryName: "my-app"})
+	}
+
+	formula, err := NewFormula(packages, FormulaInfo{Version: "1.0.0",
+		URL: "https://example.com/v{{.Version}}/{{.ArchiveName}}"})
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, "MyApp", formula.ClassName, "class name does not match")
+	assert.Equal(t, "bin/my-app", formula.Binary, "binary does not match")
+	sum := "0eb3e36bfb24dcd9bb1d1bece1531216b59539a8fde17ee80224af0653c92aa3"
+	assert.Equal(t, []FormulaArchive{{CPU: FormulaArchs["amd64"],
+		URL: "https://example.com/v1.0.0/linux_amd64.tar.gz", SHA256: sum}},
+		formula.Linux, "linux archives do not match")
+	assert.Len(t, formula.MacOS, 1, "unexpected number of macos archives")
+}
+
+func TestNewFormula_NoArchives(t *testing.T) {
+	_, err := NewFormula([]Package{{OS: "windows", Arch: "amd64", Archive: "zip"}},
+		FormulaInfo{URL: "{{.ArchiveName}}"})
+	assert.Error(t, err, "expected an error")
+}
+
+func TestRubyQuote(t *testing.T) {
+	assert.Equal(t, `"say \"hi\" \#{x} \\"`, rubyQuote(`say "hi" #{x} \`), "quoted value does not match")
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultInstallPath is where linux packages install the executable
+	DefaultInstallPath = "/usr/bin"
+	// DefaultDocPath is where linux packages install the additional files.
+	// The package name is added to the end of it.
+	DefaultDocPath = "/usr/share/doc"
+)
+
+// LinuxPackageInfo is the metadata of a linux package, set in the section of
+// the config file named after the package format
+type LinuxPackageInfo struct {
+	Name        string   `mapstructure:"name"`
+	Version     string   `mapstructure:"version"`
+	Release     string   `mapstructure:"release"`
+	Maintainer  string   `mapstructure:"maintainer"`
+	Summary     string   `mapstructure:"summary"`
+	Description string   `mapstructure:"description"`
+	License     string   `mapstructure:"license"`
+	Homepage    string   `mapstructure:"homepage"`
+	Section     string   `mapstructure:"section"`
+	Priority    string   `mapstructure:"priority"`
+	Depends     []string `mapstructure:"depends"`
+	InstallPath string   `mapstructure:"install-path"`
+	DocPath     string   `mapstructure:"doc-path"`
+}
+
+// LinuxPackageFormat is a linux package format that gop can build
+type LinuxPackageFormat struct {
+	// Archs maps the Go architectures to the package architectures. An
+	// "arch/variant" key takes precedence over the arch, and an empty value
+	// marks a variant the format can not be built for.
+	Archs map[string]string
+	// Write writes the package to w
+	Write func(w io.Writer, pkg Package, opts ArchiveOptions) error
+}
+
+// LinuxPackageFormats is the list of linux package formats keyed by archive
+var LinuxPackageFormats = map[string]LinuxPackageFormat{
+	"deb":    {Archs: DebArchs, Write: writeDeb},
+	"rpm":    {Archs: RpmArchs, Write: writeRpm},
+	"apk":    {Archs: ApkArchs, Write: writeApk},
+	"pacman": {Archs: PacmanArchs, Write: writePacman},
+}
+
+// IsSupportedPackage checks if the package can be built. Linux packages can
+// only be built for linux and the architectures their format knows about.
+func IsSupportedPackage(pkg Package) bool {
+	format, ok := LinuxPackageFormats[CanonicalArchive(pkg.Archive)]
+	if !ok {
+		return true
+	}
+	_, ok = linuxPackageArch(format.Archs, pkg)
+	return pkg.OS == "linux" && ok
+}
+
+// getLinuxPackageInfo fills in the defaults of the package info for the
+// format, and checks that the required fields are set. The package
+// architecture is looked up in archs.
+func getLinuxPackageInfo(pkg Package, opts ArchiveOptions,
+	archs map[string]string) (LinuxPackageInfo, string, error) {
+	archive := CanonicalArchive(pkg.Archive)
+	info := opts.PackageInfo[archive]
+	if pkg.OS != "linux" {
+		return info, "", errors.Errorf("%s packages can only be built for linux, not %s", archive, pkg.OS)
+	}
+	arch, ok := linuxPackageArch(archs, pkg)
+	if !ok {
+		return info, "", errors.Errorf("%s packages do not support the %s architecture",
+			archive, pkg.Platform())
+	}
+
+	if info.Name == "" {
+		info.Name = pkg.Dir
+	}
+	if info.Version == "" {
+		return info, "", errors.Errorf("%s.version must be set in the config file", archive)
+	}
+	if info.Description == "" {
+		info.Description = info.Name
+	}
+	if info.Summary == "" {
+		info.Summary = strings.TrimSpace(strings.SplitN(strings.TrimSpace(info.Description), "\n", 2)[0])
+	}
+	if info.Release == "" {
+		info.Release = "1"
+	}
+	if info.InstallPath == "" {
+		info.InstallPath = DefaultInstallPath
+	}
+	if info.DocPath == "" {
+		info.DocPath = DefaultDocPath
+	}
+	return info, arch, nil
+}
+
+// linuxPackageArch looks up the package architecture of the package, trying
+// its arch/variant pair before the arch on its own
+func linuxPackageArch(archs map[string]string, pkg Package) (string, bool) {
+	if arch, ok := archs[pkg.Platform()]; ok {
+		return arch, arch != ""
+	}
+	arch, ok := archs[pkg.Arch]
+	return arch, ok
+}
+
+// getLinuxPackageEntries creates the entries of the files installed by a
+// linux package. The executable goes in the install path and everything
+// else in a folder named after the package under the doc path. Entry names
+// are relative to the root of the filesystem, and sorted.
+func getLinuxPackageEntries(pkg Package, info LinuxPackageInfo, opts ArchiveOptions) ([]archiveEntry, error) {
+	installPath := strings.Trim(info.InstallPath, "/")
+	docPath := path.Join(strings.Trim(info.DocPath, "/"), info.Name)
+
+	entries := []archiveEntry{}
+	for _, file := range pkg.FileList {
+		name := path.Join(docPath, pkg.FileName(file))
+		mode := ExtraFileMode
+		if file == pkg.ExePath {
+			mode = ExeFileMode
+			name = path.Join(installPath, filepath.Base(file))
+			if pkg.BinaryName != "" {
+				name = path.Join(installPath, pkg.BinaryName)
+			}
+		}
+		fileEntries, err := getArchiveFileEntries(file, name, mode, opts.Modes, pkg.Excludes)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fileEntries...)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	if opts.Reproducible {
+		entries = makeReproducible(entries, opts.ModTime)
+	}
+	return entries, nil
+}
+
+// addParentEntries adds a directory entry for every parent folder of the
+// entries that does not already have one. The result is sorted.
+func addParentEntries(entries []archiveEntry, modTime time.Time) []archiveEntry {
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		seen[entry.name] = true
+	}
+	for _, entry := range entries {
+		for dir := path.Dir(entry.name); dir != "." && dir != "/" && !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			entries = append(entries, archiveEntry{
+				name: dir,
+				info: memInfo{name: path.Base(dir), mode: os.ModeDir | DirFileMode, modTime: modTime},
+			})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	return entries
+}
+
+// writeLinuxTar writes the entries to a tar stream owned by root. Names are
+// given the prefix, and directories a trailing slash.
+func writeLinuxTar(w io.Writer, entries []archiveEntry, prefix string) error {
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		hdr, err := linuxTarHeader(entry, prefix)
+		if err != nil {
+			return err
+		}
+		if err := writeLinuxTarEntry(tw, hdr, entry); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(tw.Close(), "closing tar")
+}
+
+// linuxTarHeader creates the tar header of an entry owned by root
+func linuxTarHeader(entry archiveEntry, prefix string) (*tar.Header, error) {
+	hdr := &tar.Header{
+		Name:     prefix + entry.name,
+		Mode:     int64(entry.info.Mode().Perm()),
+		ModTime:  entry.info.ModTime().Truncate(time.Second),
+		Uname:    "root",
+		Gname:    "root",
+		Typeflag: tar.TypeReg,
+		Size:     entry.info.Size(),
+		Format:   tar.FormatGNU,
+	}
+	switch {
+	case entry.info.IsDir():
+		hdr.Name += "/"
+		hdr.Typeflag = tar.TypeDir
+		hdr.Size = 0
+	case !entry.info.Mode().IsRegular():
+		return nil, errors.Errorf("unsupported file type: %s", entry.name)
+	}
+	return hdr, nil
+}
+
+// writeLinuxTarEntry writes the header, along with the contents of any
+// regular file
+func writeLinuxTarEntry(tw *tar.Writer, hdr *tar.Header, entry archiveEntry) error {
+	if err := tw.WriteHeader(hdr); err != nil {
+		return errors.Wrapf(err, "writing %s", entry.name)
+	}
+
+	file, err := openArchiveEntry(entry)
+	if err != nil || file == nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(tw, file)
+	return errors.Wrapf(err, "writing %s", entry.name)
+}
+
+// metadataEntry creates the entry of a package metadata file
+func metadataEntry(name string, data []byte, modTime time.Time) archiveEntry {
+	return archiveEntry{
+		name: name,
+		data: data,
+		info: memInfo{name: name, size: int64(len(data)), mode: ExtraFileMode, modTime: modTime},
+	}
+}
+
+// linuxPackageModTime is the timestamp of the metadata files of a package
+func linuxPackageModTime(opts ArchiveOptions) time.Time {
+	if opts.Reproducible {
+		return opts.ModTime
+	}
+	return time.Now()
+}
+
+// installedSize is the total size in bytes of the regular files in entries
+func installedSize(entries []archiveEntry) int64 {
+	var size int64
+	for _, entry := range entries {
+		if entry.info.Mode().IsRegular() {
+			size += entry.info.Size()
+		}
+	}
+	return size
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Manifest describes the archives produced by a single run of gop
+type Manifest struct {
+	Version           string                 `json:"version"`
+	Config            map[string]interface{} `json:"config"`
+	ChecksumAlgorithm string                 `json:"checksum_algorithm"`
+	Packages          []ManifestPackage      `json:"packages"`
+}
+
+// ManifestPackage is a package along with details about the produced archive
+type ManifestPackage struct {
+	Package
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// NewManifest creates a manifest describing the archives of the packages
+func NewManifest(packages []Package, checksumAlgorithm string,
+	config map[string]interface{}) (Manifest, error) {
+	manifest := Manifest{
+		Version:           buildVersion,
+		Config:            config,
+		ChecksumAlgorithm: checksumAlgorithm,
+		Packages:          []ManifestPackage{},
+	}
+
+	for _, pkg := range packages {
+		info, err := os.Stat(pkg.ArchivePath)
+		if err != nil {
+			return manifest, errors.Wrapf(err, "reading %s", pkg.ArchivePath)
+		}
+		manifest.Packages = append(manifest.Packages, ManifestPackage{
+			Package: pkg,
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+		})
+	}
+	return manifest, nil
+}
+
+// WriteManifest writes the manifest to path as JSON
+func WriteManifest(path string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding manifest")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "making manifest folder")
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return errors.Wrapf(err, "writing %s", path)
+	}
+	return nil
+}
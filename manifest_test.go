@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	archivePath := filepath.Join(dir, "exe_linux_amd64.tar.gz")
+	assert.NoError(t, ioutil.WriteFile(archivePath, []byte("archive"), 0644))
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(archivePath, modTime, modTime))
+
+	pkgs := []Package{Package{OS: "linux", Arch: "amd64", Archive: "tar.gz", Dir: "exe",
+		ExePath: "exe_linux_amd64", ArchivePath: archivePath,
+		FileList: []string{"exe_linux_amd64", "README.md"}, Checksum: "abc123"}}
+	manifest, err := NewManifest(pkgs, "sha256", map[string]interface{}{"archive": []string{"tar.gz"}})
+	assert.NoError(t, err, "unexpected error")
+
+	manifestPath := filepath.Join(dir, "out", "manifest.json")
+	assert.NoError(t, WriteManifest(manifestPath, manifest))
+	data, err := ioutil.ReadFile(manifestPath)
+	assert.NoError(t, err, "unexpected error")
+
+	var result map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &result))
+	assert.Equal(t, buildVersion, result["version"], "version does not match")
+	assert.Equal(t, "sha256", result["checksum_algorithm"], "checksum algorithm does not match")
+	assert.Equal(t, map[string]interface{}{"archive": []interface{}{"tar.gz"}}, result["config"],
+		"config does not match")
+
+	packages := result["packages"].([]interface{})
+	assert.Len(t, packages, 1, "incorrect number of packages")
+	expected := map[string]interface{}{
+		"os":           "linux",
+		"arch":         "amd64",
+		"archive":      "tar.gz",
+		"dir":          "exe",
+		"exe_path":     "exe_linux_amd64",
+		"archive_path": archivePath,
+		"files":        []interface{}{"exe_linux_amd64", "README.md"},
+		"checksum":     "abc123",
+		"size":         float64(len("archive")),
+		"mtime":        "2020-01-02T03:04:05Z",
+	}
+	assert.Equal(t, expected, packages[0], "package does not match")
+}
+
+func TestNewManifest_MissingArchive(t *testing.T) {
+	pkgs := []Package{Package{OS: "linux", Arch: "amd64", Archive: "zip", ArchivePath: "missing.zip"}}
+	_, err := NewManifest(pkgs, "sha256", map[string]interface{}{})
+	assert.Error(t, err, "expected an error")
+}
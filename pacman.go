@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// PacmanArchs maps the Go architectures to the Arch Linux architectures
+var PacmanArchs = map[string]string{
+	"amd64": "x86_64",
+	"arm64": "aarch64",
+}
+
+// writePacman writes an Arch Linux package. It is a zstd compressed tarball
+// holding the .PKGINFO metadata, the .MTREE file list and the installed
+// files. The compression level of tar.zst archives is used.
+func writePacman(w io.Writer, pkg Package, opts ArchiveOptions) error {
+	info, arch, err := getLinuxPackageInfo(pkg, opts, PacmanArchs)
+	if err != nil {
+		return err
+	}
+
+	modTime := linuxPackageModTime(opts)
+	files, err := getLinuxPackageEntries(pkg, info, opts)
+	if err != nil {
+		return err
+	}
+	files = addParentEntries(files, modTime)
+
+	pkgInfo := metadataEntry(".PKGINFO",
+		pacmanPkgInfo(info, arch, installedSize(files), modTime.Unix()), modTime)
+	mtree, err := pacmanMtree(append([]archiveEntry{pkgInfo}, files...))
+	if err != nil {
+		return err
+	}
+	entries := append([]archiveEntry{pkgInfo, metadataEntry(".MTREE", mtree, modTime)}, files...)
+
+	level := opts.ZstdLevel
+	if level == 0 {
+		level = DefaultZstdLevel
+	}
+	zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		return errors.Wrap(err, "creating zstd writer")
+	}
+	if err := writeLinuxTar(zw, entries, ""); err != nil {
+		zw.Close()
+		return err
+	}
+	return errors.Wrap(zw.Close(), "closing zstd")
+}
+
+// pacmanPkgInfo generates the .PKGINFO file of the package
+func pacmanPkgInfo(info LinuxPackageInfo, arch string, size int64, buildDate int64) []byte {
+	var pkgInfo bytes.Buffer
+	fmt.Fprintf(&pkgInfo, "# Generated by gop\n")
+	fmt.Fprintf(&pkgInfo, "pkgname = %s\n", info.Name)
+	fmt.Fprintf(&pkgInfo, "pkgbase = %s\n", info.Name)
+	fmt.Fprintf(&pkgInfo, "pkgver = %s-%s\n", info.Version, info.Release)
+	fmt.Fprintf(&pkgInfo, "pkgdesc = %s\n", info.Summary)
+	if info.Homepage != "" {
+		fmt.Fprintf(&pkgInfo, "url = %s\n", info.Homepage)
+	}
+	fmt.Fprintf(&pkgInfo, "builddate = %d\n", buildDate)
+	if info.Maintainer != "" {
+		fmt.Fprintf(&pkgInfo, "packager = %s\n", info.Maintainer)
+	}
+	fmt.Fprintf(&pkgInfo, "size = %d\n", size)
+	fmt.Fprintf(&pkgInfo, "arch = %s\n", arch)
+	if info.License != "" {
+		fmt.Fprintf(&pkgInfo, "license = %s\n", info.License)
+	}
+	for _, dep := range info.Depends {
+		// pacman writes versioned dependencies without spaces, e.g. "glibc>=2.17"
+		fmt.Fprintf(&pkgInfo, "depend = %s\n", strings.Join(strings.Fields(dep), ""))
+	}
+	return pkgInfo.Bytes()
+}
+
+// pacmanMtree generates the gzipped .MTREE file pacman uses to validate
+// the installed files, in the same format written by bsdtar
+func pacmanMtree(entries []archiveEntry) ([]byte, error) {
+	var mtree bytes.Buffer
+	gz := gzip.NewWriter(&mtree)
+	fmt.Fprintf(gz, "#mtree\n")
+	fmt.Fprintf(gz, "/set type=file uid=0 gid=0 mode=644\n")
+	for _, entry := range entries {
+		name := "./" + mtreeEscape(entry.name)
+		mtime := fmt.Sprintf("time=%d.0", entry.info.ModTime().Truncate(time.Second).Unix())
+		mode := entry.info.Mode().Perm()
+		if entry.info.IsDir() {
+			fmt.Fprintf(gz, "%s %s mode=%o type=dir\n", name, mtime, mode)
+			continue
+		}
+
+		data, err := readArchiveEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(gz, "%s %s", name, mtime)
+		if mode != 0644 {
+			fmt.Fprintf(gz, " mode=%o", mode)
+		}
+		fmt.Fprintf(gz, " size=%d md5digest=%x sha256digest=%x\n",
+			len(data), md5.Sum(data), sha256.Sum256(data))
+	}
+	if err := gz.Close(); err != nil {
+		return nil, errors.Wrap(err, "creating .MTREE")
+	}
+	return mtree.Bytes(), nil
+}
+
+// mtreeEscape escapes the characters in a file name that have a meaning
+// in mtree files as octal
+func mtreeEscape(name string) string {
+	var escaped strings.Builder
+	for _, c := range []byte(name) {
+		if c <= ' ' || c >= 0x7f || c == '#' || c == '=' || c == '\\' {
+			fmt.Fprintf(&escaped, "\\%03o", c)
+		} else {
+			escaped.WriteByte(c)
+		}
+	}
+	return escaped.String()
+}
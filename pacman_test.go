@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacmanPkgInfo(t *testing.T) {
+	info := LinuxPackageInfo{
+		Name:    "gop",
+		Version: "1.0.0",
+		Release: "1",
+		Summary: "Go packager",
+		Depends: []string{"glibc >= 2.17"},
+	}
+	expected := "# Generated by gop\n" +
+		"pkgname = gop\n" +
+		"pkgbase = gop\n" +
+		"pkgver = 1.0.0-1\n" +
+		"pkgdesc = Go packager\n" +
+		"builddate = 315532800\n" +
+		"size = 1024\n" +
+		"arch = aarch64\n" +
+		"depend = glibc>=2.17\n"
+	pkgInfo := pacmanPkgInfo(info, PacmanArchs["arm64"], 1024, ReproducibleModTime.Unix())
+	assert.Equal(t, expected, string(pkgInfo), "pkginfo does not match")
+}
+
+func TestMtreeEscape(t *testing.T) {
+	assert.Equal(t, "usr/share/doc/my\\040app/a\\075b", mtreeEscape("usr/share/doc/my app/a=b"),
+		"escaped name does not match")
+}
+
+func TestWritePacman(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "exe")
+	readmePath := filepath.Join(dir, "README.md")
+	assert.NoError(t, ioutil.WriteFile(exePath, []byte("binary"), 0700))
+	assert.NoError(t, ioutil.WriteFile(readmePath, []byte("readme"), 0600))
+
+	pkg := Package{OS: "linux", Arch: "amd64", Dir: "exe", Archive: "pacman", ExePath: exePath,
+		FileList: []string{exePath, readmePath}}
+	opts := ArchiveOptions{Reproducible: true, ModTime: ReproducibleModTime,
+		PackageInfo: map[string]LinuxPackageInfo{"pacman": {Version: "1.0.0"}}}
+	var out bytes.Buffer
+	assert.NoError(t, writePacman(&out, pkg, opts), "unexpected error")
+
+	zr, err := zstd.NewReader(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err, "unexpected error")
+	defer zr.Close()
+	entries := readTestTar(t, zr, false)
+
+	// pacman reads the metadata from the start of the package
+	assert.Equal(t, []string{".PKGINFO", ".MTREE", "usr/", "usr/bin/", "usr/bin/exe", "usr/share/",
+		"usr/share/doc/", "usr/share/doc/exe/", "usr/share/doc/exe/README.md"}, testTarNames(entries),
+		"package entries do not match")
+	assert.Contains(t, entries[0].data, "pkgname = exe\npkgbase = exe\npkgver = 1.0.0-1\n",
+		"pkginfo does not match")
+	assert.Contains(t, entries[0].data, "size = 12\narch = x86_64\n", "pkginfo does not match")
+	assert.Equal(t, testTarEntry{"usr/bin/exe", 0755, "binary"}, entries[4], "executable does not match")
+	assert.Equal(t, testTarEntry{"usr/share/doc/exe/README.md", 0644, "readme"}, entries[8],
+		"readme does not match")
+
+	gz, err := gzip.NewReader(strings.NewReader(entries[1].data))
+	assert.NoError(t, err, "unexpected error")
+	mtree, err := ioutil.ReadAll(gz)
+	assert.NoError(t, err, "unexpected error")
+	lines := strings.Split(strings.TrimSpace(string(mtree)), "\n")
+	assert.Len(t, lines, 10, "incorrect number of mtree lines")
+	assert.Equal(t, "#mtree", lines[0], "mtree header does not match")
+	assert.Equal(t, "./usr/bin time=315532800.0 mode=755 type=dir", lines[4], "mtree dir does not match")
+	sha := sha256.Sum256([]byte("binary"))
+	assert.True(t, strings.HasPrefix(lines[5], "./usr/bin/exe time=315532800.0 mode=755 size=6 "),
+		"mtree file does not match")
+	assert.True(t, strings.HasSuffix(lines[5], fmt.Sprintf(" sha256digest=%x", sha)),
+		"mtree digest does not match")
+}
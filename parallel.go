@@ -0,0 +1,87 @@
+package main
+
+import "sync"
+
+// PackageFunc packages a single package
+type PackageFunc func(pkg *Package) error
+
+// PackageDoneFunc is called with the outcome of each packaged package
+type PackageDoneFunc func(pkg Package, err error)
+
+// PackageParallel calls packageFn for every package using a pool of at most
+// workers goroutines. The results are handed to doneFn in the same order as
+// the given packages, no matter which worker finishes first, so any output
+// generated by doneFn is deterministic.
+//
+// If keepGoing is false, no new packages are started after the first failure.
+// Packages that were never started are not handed to doneFn. The number of
+// packages that were attempted is returned.
+func PackageParallel(packages []Package, workers int, keepGoing bool,
+	packageFn PackageFunc, doneFn PackageDoneFunc) int {
+	if workers < 1 {
+		workers = 1
+	}
+
+	type result struct {
+		pkg     Package
+		err     error
+		skipped bool
+	}
+
+	jobs := make(chan int)
+	abort := make(chan struct{})
+	var abortOnce sync.Once
+	results := make([]chan result, len(packages))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				pkg := packages[i]
+				err := packageFn(&pkg)
+				if err != nil && !keepGoing {
+					abortOnce.Do(func() { close(abort) })
+				}
+				results[i] <- result{pkg: pkg, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		skipFrom := func(i int) {
+			for ; i < len(packages); i++ {
+				results[i] <- result{skipped: true}
+			}
+		}
+		for i := range packages {
+			// a select picks at random when both cases are ready, so an abort
+			// is checked on its own before a job is offered
+			select {
+			case <-abort:
+				skipFrom(i)
+				return
+			default:
+			}
+			select {
+			case <-abort:
+				skipFrom(i)
+				return
+			case jobs <- i:
+			}
+		}
+	}()
+
+	attempted := 0
+	for i := range packages {
+		r := <-results[i]
+		if r.skipped {
+			break
+		}
+		attempted++
+		doneFn(r.pkg, r.err)
+	}
+	return attempted
+}
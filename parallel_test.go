@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackageParallel_Ordered(t *testing.T) {
+	pkgs := []Package{}
+	for i := 0; i < 20; i++ {
+		pkgs = append(pkgs, Package{OS: "linux", Arch: fmt.Sprintf("%d", i), Archive: "zip"})
+	}
+
+	results := []string{}
+	PackageParallel(pkgs, 4, true, func(pkg *Package) error {
+		// finish the early packages last
+		i, _ := strconv.Atoi(pkg.Arch)
+		time.Sleep(time.Duration(20-i) * time.Millisecond)
+		pkg.Checksum = pkg.Arch
+		return nil
+	}, func(pkg Package, err error) {
+		assert.NoError(t, err, "unexpected error")
+		results = append(results, pkg.Checksum)
+	})
+
+	assert.Len(t, results, len(pkgs), "incorrect number of results")
+	for i, pkg := range pkgs {
+		assert.Equal(t, pkg.Arch, results[i], "results are out of order")
+	}
+}
+
+func TestPackageParallel_Errors(t *testing.T) {
+	pkgs := []Package{Package{OS: "linux"}, Package{OS: "windows"}, Package{OS: "darwin"}}
+
+	failed := []string{}
+	PackageParallel(pkgs, 0, true, func(pkg *Package) error {
+		if pkg.OS == "windows" {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	}, func(pkg Package, err error) {
+		if err != nil {
+			failed = append(failed, pkg.OS)
+		}
+	})
+
+	assert.Equal(t, []string{"windows"}, failed, "failed packages do not match")
+}
+
+func TestPackageParallel_Abort(t *testing.T) {
+	pkgs := []Package{Package{OS: "linux"}, Package{OS: "windows"}, Package{OS: "darwin"}}
+
+	done := []string{}
+	attempted := PackageParallel(pkgs, 1, false, func(pkg *Package) error {
+		if pkg.OS == "windows" {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	}, func(pkg Package, err error) {
+		done = append(done, pkg.OS)
+	})
+
+	assert.Equal(t, 2, attempted, "incorrect number of attempted packages")
+	assert.Equal(t, []string{"linux", "windows"}, done, "done packages do not match")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GoPlatform is an OS/arch pair that Go can build for
+type GoPlatform struct {
+	OS   string `json:"GOOS"`
+	Arch string `json:"GOARCH"`
+}
+
+// ValidPlatforms are the OS/arch pairs that Go can build for. If there are
+// none, every pairing of OSList and ArchList is taken to be valid.
+var ValidPlatforms []GoPlatform
+
+// LoadPlatforms replaces OSList, ArchList and ValidPlatforms with the
+// platforms listed by "go tool dist list". The built in lists are left as
+// they are if the go tool could not be run.
+func LoadPlatforms(goCmd string) error {
+	output, err := execGo(goCmd, nil, "", "tool", "dist", "list", "-json")
+	if err != nil {
+		return errors.Wrap(err, "listing go platforms")
+	}
+	platforms, err := ParsePlatforms([]byte(output))
+	if err != nil {
+		return err
+	}
+
+	ValidPlatforms = platforms
+	OSList, ArchList = platformLists(platforms)
+	return nil
+}
+
+// ParsePlatforms parses the JSON output of "go tool dist list -json"
+func ParsePlatforms(data []byte) ([]GoPlatform, error) {
+	platforms := []GoPlatform{}
+	if err := json.Unmarshal(data, &platforms); err != nil {
+		return nil, errors.Wrap(err, "parsing go platforms")
+	}
+	if len(platforms) == 0 {
+		return nil, errors.New("no go platforms found")
+	}
+	return platforms, nil
+}
+
+// IsValidPlatform checks if Go can build for the OS/arch pair
+func IsValidPlatform(goos string, goarch string) bool {
+	if len(ValidPlatforms) == 0 {
+		return true
+	}
+	for _, platform := range ValidPlatforms {
+		if strings.EqualFold(platform.OS, goos) && strings.EqualFold(platform.Arch, goarch) {
+			return true
+		}
+	}
+	return false
+}
+
+// platformLists returns the sorted OSs and architectures of the platforms
+func platformLists(platforms []GoPlatform) ([]string, []string) {
+	osList, archList := []string{}, []string{}
+	for _, platform := range platforms {
+		if !containsItem(osList, platform.OS) {
+			osList = append(osList, platform.OS)
+		}
+		if !containsItem(archList, platform.Arch) {
+			archList = append(archList, platform.Arch)
+		}
+	}
+	sort.Strings(osList)
+	sort.Strings(archList)
+	return osList, archList
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	platforms, err := ParsePlatforms([]byte(`[
+		{"GOOS": "linux", "GOARCH": "riscv64", "CgoSupported": true, "FirstClass": false},
+		{"GOOS": "js", "GOARCH": "wasm", "CgoSupported": false, "FirstClass": false},
+		{"GOOS": "darwin", "GOARCH": "arm64", "CgoSupported": true, "FirstClass": true},
+		{"GOOS": "linux", "GOARCH": "arm64", "CgoSupported": true, "FirstClass": true}
+	]`))
+	assert.NoError(t, err, "unexpected error")
+	expected := []GoPlatform{{"linux", "riscv64"}, {"js", "wasm"}, {"darwin", "arm64"}, {"linux", "arm64"}}
+	assert.Equal(t, expected, platforms, "platforms do not match")
+
+	osList, archList := platformLists(platforms)
+	assert.Equal(t, []string{"darwin", "js", "linux"}, osList, "os list does not match")
+	assert.Equal(t, []string{"arm64", "riscv64", "wasm"}, archList, "arch list does not match")
+
+	_, err = ParsePlatforms([]byte(`not json`))
+	assert.Error(t, err, "expected an error")
+}
+
+func TestAssemblePackageInfo_ValidPlatforms(t *testing.T) {
+	ValidPlatforms = []GoPlatform{{"linux", "riscv64"}, {"js", "wasm"}, {"wasip1", "wasm"}}
+	defer func() { ValidPlatforms = nil }()
+
+	results, err := AssemblePackageInfo([]string{"riscv64", "wasm"}, []string{"linux", "js"},
+		[]string{"zip"}, []string{})
+	assert.NoError(t, err, "unexpected error")
+	expected := []Package{
+		Package{OS: "linux", Arch: "riscv64", Archive: "zip"},
+		Package{OS: "js", Arch: "wasm", Archive: "zip"},
+	}
+	assert.Equal(t, expected, results, "packages do not match")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// releaseURLData is the data given to the download url templates of the
+// package manager files
+type releaseURLData struct {
+	Package
+	ArchiveName string
+	Version     string
+}
+
+// getReleaseURL generates the download url of the package archive, along
+// with the sha256 of the archive the url points to
+func getReleaseURL(urlTpl *template.Template, pkg Package, version string) (string, string, error) {
+	var url bytes.Buffer
+	data := releaseURLData{Package: pkg, ArchiveName: filepath.Base(pkg.ArchivePath),
+		Version: version}
+	if err := urlTpl.Execute(&url, data); err != nil {
+		return "", "", errors.Wrap(err, "error generating download url")
+	}
+	sum, err := sha256File(pkg.ArchivePath)
+	return url.String(), sum, err
+}
+
+// sha256File calculates the hex encoded sha256 of the file at path
+func sha256File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading %s", path)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", errors.Wrapf(err, "reading %s", path)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RpmArchs maps the Go architectures to the RPM architectures
+var RpmArchs = map[string]string{
+	"amd64":   "x86_64",
+	"386":     "i686",
+	"arm64":   "aarch64",
+	"ppc64le": "ppc64le",
+}
+
+// RPM header data types
+const (
+	rpmInt16       = 3
+	rpmInt32       = 4
+	rpmString      = 6
+	rpmBinary      = 7
+	rpmStringArray = 8
+	rpmI18NString  = 9
+)
+
+// RPM header tags
+const (
+	rpmTagHeaderSignatures  = 62
+	rpmTagHeaderImmutable   = 63
+	rpmTagName              = 1000
+	rpmTagVersion           = 1001
+	rpmTagRelease           = 1002
+	rpmTagSummary           = 1004
+	rpmTagDescription       = 1005
+	rpmTagBuildTime         = 1006
+	rpmTagBuildHost         = 1007
+	rpmTagSize              = 1009
+	rpmTagLicense           = 1014
+	rpmTagPackager          = 1015
+	rpmTagGroup             = 1016
+	rpmTagURL               = 1020
+	rpmTagOS                = 1021
+	rpmTagArch              = 1022
+	rpmTagFileSizes         = 1028
+	rpmTagFileModes         = 1030
+	rpmTagFileRdevs         = 1033
+	rpmTagFileMTimes        = 1034
+	rpmTagFileDigests       = 1035
+	rpmTagFileLinkTos       = 1036
+	rpmTagFileFlags         = 1037
+	rpmTagFileUserName      = 1039
+	rpmTagFileGroupName     = 1040
+	rpmTagProvideName       = 1047
+	rpmTagRequireFlags      = 1048
+	rpmTagRequireName       = 1049
+	rpmTagRequireVersion    = 1050
+	rpmTagFileDevices       = 1095
+	rpmTagFileInodes        = 1096
+	rpmTagFileLangs         = 1097
+	rpmTagProvideFlags      = 1112
+	rpmTagProvideVersion    = 1113
+	rpmTagDirIndexes        = 1116
+	rpmTagBaseNames         = 1117
+	rpmTagDirNames          = 1118
+	rpmTagPayloadFormat     = 1124
+	rpmTagPayloadCompressor = 1125
+	rpmTagPayloadFlags      = 1126
+	rpmTagFileDigestAlgo    = 5011
+
+	rpmSigTagSHA256      = 273
+	rpmSigTagSize        = 1000
+	rpmSigTagMD5         = 1004
+	rpmSigTagPayloadSize = 1007
+)
+
+// RPM dependency and file flags
+const (
+	rpmSenseLess    = 1 << 1
+	rpmSenseGreater = 1 << 2
+	rpmSenseEqual   = 1 << 3
+	rpmSenseRpmlib  = 1 << 24
+	rpmFileDoc      = 1 << 1
+	rpmDigestSHA256 = 8
+)
+
+// writeRpm writes an RPM package. It is made up of the lead, a signature
+// header holding the digests of the package, the main header with the
+// package metadata and file list, and a gzipped cpio payload with the files.
+// The package is not signed.
+func writeRpm(w io.Writer, pkg Package, opts ArchiveOptions) error {
+	info, arch, err := getLinuxPackageInfo(pkg, opts, RpmArchs)
+	if err != nil {
+		return err
+	}
+
+	files, err := getLinuxPackageEntries(pkg, info, opts)
+	if err != nil {
+		return err
+	}
+
+	var payload bytes.Buffer
+	gz := gzip.NewWriter(&payload)
+	payloadSize, err := writeCpio(gz, files)
+	if err != nil {
+		return errors.Wrap(err, "creating payload")
+	}
+	if err := gz.Close(); err != nil {
+		return errors.Wrap(err, "creating payload")
+	}
+
+	header, err := rpmMainHeader(info, arch, files, opts)
+	if err != nil {
+		return err
+	}
+	headerBytes := header.Bytes(rpmTagHeaderImmutable)
+
+	md5sum := md5.New()
+	md5sum.Write(headerBytes)
+	md5sum.Write(payload.Bytes())
+	sha := sha256.Sum256(headerBytes)
+
+	sig := newRpmHeader()
+	sig.AddString(rpmSigTagSHA256, hex.EncodeToString(sha[:]))
+	sig.AddInt32(rpmSigTagSize, int32(len(headerBytes)+payload.Len()))
+	sig.AddBinary(rpmSigTagMD5, md5sum.Sum(nil))
+	sig.AddInt32(rpmSigTagPayloadSize, int32(payloadSize))
+	sigBytes := sig.Bytes(rpmTagHeaderSignatures)
+	// the main header starts on an 8 byte boundary
+	sigBytes = append(sigBytes, make([]byte, (8-len(sigBytes)%8)%8)...)
+
+	for _, data := range [][]byte{
+		rpmLead(fmt.Sprintf("%s-%s-%s", info.Name, info.Version, info.Release)),
+		sigBytes, headerBytes, payload.Bytes(),
+	} {
+		if _, err := w.Write(data); err != nil {
+			return errors.Wrap(err, "writing rpm")
+		}
+	}
+	return nil
+}
+
+// rpmLead creates the lead of a binary package. Only the magic and package
+// type are still used by rpm, everything else is in the headers.
+func rpmLead(name string) []byte {
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	// the name is nul terminated
+	copy(lead[10:75], name)
+	// os is linux and the signature is in a header
+	binary.BigEndian.PutUint16(lead[76:], 1)
+	binary.BigEndian.PutUint16(lead[78:], 5)
+	return lead
+}
+
+// rpmMainHeader creates the header with the package metadata and file list
+func rpmMainHeader(info LinuxPackageInfo, arch string, files []archiveEntry,
+	opts ArchiveOptions) (*rpmHeader, error) {
+	modTime := linuxPackageModTime(opts)
+	buildHost := "localhost"
+	if !opts.Reproducible {
+		if host, err := os.Hostname(); err == nil {
+			buildHost = host
+		}
+	}
+
+	h := newRpmHeader()
+	h.AddString(rpmTagName, info.Name)
+	h.AddString(rpmTagVersion, info.Version)
+	h.AddString(rpmTagRelease, info.Release)
+	h.AddStrings(rpmTagSummary, rpmI18NString, info.Summary)
+	h.AddStrings(rpmTagDescription, rpmI18NString, strings.TrimSpace(info.Description))
+	h.AddInt32(rpmTagBuildTime, int32(modTime.Unix()))
+	h.AddString(rpmTagBuildHost, buildHost)
+	h.AddInt32(rpmTagSize, int32(installedSize(files)))
+	if info.License != "" {
+		h.AddString(rpmTagLicense, info.License)
+	}
+	if info.Maintainer != "" {
+		h.AddString(rpmTagPackager, info.Maintainer)
+	}
+	h.AddStrings(rpmTagGroup, rpmI18NString, "Unspecified")
+	if info.Homepage != "" {
+		h.AddString(rpmTagURL, info.Homepage)
+	}
+	h.AddString(rpmTagOS, "linux")
+	h.AddString(rpmTagArch, arch)
+	h.AddStrings(rpmTagPayloadFormat, rpmString, "cpio")
+	h.AddStrings(rpmTagPayloadCompressor, rpmString, "gzip")
+	h.AddStrings(rpmTagPayloadFlags, rpmString, "9")
+
+	h.AddStrings(rpmTagProvideName, rpmStringArray, info.Name)
+	h.AddInt32(rpmTagProvideFlags, rpmSenseEqual)
+	h.AddStrings(rpmTagProvideVersion, rpmStringArray, info.Version+"-"+info.Release)
+
+	requireNames := []string{"rpmlib(CompressedFileNames)", "rpmlib(FileDigests)",
+		"rpmlib(PayloadFilesHavePrefix)"}
+	requireVersions := []string{"3.0.4-1", "4.6.0-1", "4.0-1"}
+	requireFlags := []int32{}
+	for range requireNames {
+		requireFlags = append(requireFlags, rpmSenseRpmlib|rpmSenseLess|rpmSenseEqual)
+	}
+	for _, dep := range info.Depends {
+		name, flags, version, err := parseRpmDependency(dep)
+		if err != nil {
+			return nil, err
+		}
+		requireNames = append(requireNames, name)
+		requireFlags = append(requireFlags, flags)
+		requireVersions = append(requireVersions, version)
+	}
+	h.AddStrings(rpmTagRequireName, rpmStringArray, requireNames...)
+	h.AddInt32(rpmTagRequireFlags, requireFlags...)
+	h.AddStrings(rpmTagRequireVersion, rpmStringArray, requireVersions...)
+
+	docPath := "/" + path.Join(strings.Trim(info.DocPath, "/"), info.Name) + "/"
+	var sizes, mtimes, fileFlags, devices, inodes, dirIndexes []int32
+	var modes, rdevs []int16
+	var digests, linkTos, users, groups, langs, baseNames, dirNames []string
+	dirs := map[string]int32{}
+	for i, file := range files {
+		name := "/" + file.name
+		data, err := readArchiveEntry(file)
+		if err != nil {
+			return nil, err
+		}
+		digest := ""
+		if file.info.Mode().IsRegular() {
+			sum := sha256.Sum256(data)
+			digest = hex.EncodeToString(sum[:])
+		}
+		flags := int32(0)
+		if strings.HasPrefix(name, docPath) {
+			flags = rpmFileDoc
+		}
+
+		dir := path.Dir(name) + "/"
+		if _, ok := dirs[dir]; !ok {
+			dirs[dir] = int32(len(dirNames))
+			dirNames = append(dirNames, dir)
+		}
+
+		sizes = append(sizes, int32(len(data)))
+		modes = append(modes, int16(cpioMode(file.info)))
+		rdevs = append(rdevs, 0)
+		mtimes = append(mtimes, int32(file.info.ModTime().Unix()))
+		digests = append(digests, digest)
+		linkTos = append(linkTos, "")
+		fileFlags = append(fileFlags, flags)
+		users = append(users, "root")
+		groups = append(groups, "root")
+		devices = append(devices, 1)
+		inodes = append(inodes, int32(i+1))
+		langs = append(langs, "")
+		dirIndexes = append(dirIndexes, dirs[dir])
+		baseNames = append(baseNames, path.Base(name))
+	}
+	if len(files) > 0 {
+		h.AddInt32(rpmTagFileSizes, sizes...)
+		h.AddInt16(rpmTagFileModes, modes...)
+		h.AddInt16(rpmTagFileRdevs, rdevs...)
+		h.AddInt32(rpmTagFileMTimes, mtimes...)
+		h.AddStrings(rpmTagFileDigests, rpmStringArray, digests...)
+		h.AddStrings(rpmTagFileLinkTos, rpmStringArray, linkTos...)
+		h.AddInt32(rpmTagFileFlags, fileFlags...)
+		h.AddStrings(rpmTagFileUserName, rpmStringArray, users...)
+		h.AddStrings(rpmTagFileGroupName, rpmStringArray, groups...)
+		h.AddInt32(rpmTagFileDevices, devices...)
+		h.AddInt32(rpmTagFileInodes, inodes...)
+		h.AddStrings(rpmTagFileLangs, rpmStringArray, langs...)
+		h.AddInt32(rpmTagDirIndexes, dirIndexes...)
+		h.AddStrings(rpmTagBaseNames, rpmStringArray, baseNames...)
+		h.AddStrings(rpmTagDirNames, rpmStringArray, dirNames...)
+		h.AddInt32(rpmTagFileDigestAlgo, rpmDigestSHA256)
+	}
+	return h, nil
+}
+
+// parseRpmDependency splits a dependency like "glibc >= 2.17" into its name,
+// comparison flags and version
+func parseRpmDependency(dep string) (string, int32, string, error) {
+	fields := strings.Fields(dep)
+	switch len(fields) {
+	case 1:
+		return fields[0], 0, "", nil
+	case 3:
+		flags, ok := map[string]int32{
+			"<":  rpmSenseLess,
+			"<=": rpmSenseLess | rpmSenseEqual,
+			"=":  rpmSenseEqual,
+			">=": rpmSenseGreater | rpmSenseEqual,
+			">":  rpmSenseGreater,
+		}[fields[1]]
+		if ok {
+			return fields[0], flags, fields[2], nil
+		}
+	}
+	return "", 0, "", errors.Errorf("invalid dependency '%s'", dep)
+}
+
+// readArchiveEntry reads the contents of a regular file entry
+func readArchiveEntry(entry archiveEntry) ([]byte, error) {
+	file, err := openArchiveEntry(entry)
+	if err != nil || file == nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	return data, errors.Wrapf(err, "reading %s", entry.name)
+}
+
+// rpmHeader is a header structure made up of tagged entries
+type rpmHeader struct {
+	entries map[int]rpmEntry
+}
+
+type rpmEntry struct {
+	dataType int
+	count    int
+	data     []byte
+}
+
+func newRpmHeader() *rpmHeader {
+	return &rpmHeader{entries: map[int]rpmEntry{}}
+}
+
+// AddString adds a single string entry
+func (h *rpmHeader) AddString(tag int, value string) {
+	h.AddStrings(tag, rpmString, value)
+}
+
+// AddStrings adds an entry of the given string type holding the values
+func (h *rpmHeader) AddStrings(tag int, dataType int, values ...string) {
+	var data bytes.Buffer
+	for _, value := range values {
+		data.WriteString(value)
+		data.WriteByte(0)
+	}
+	h.entries[tag] = rpmEntry{dataType: dataType, count: len(values), data: data.Bytes()}
+}
+
+// AddInt32 adds an entry holding the 32 bit values
+func (h *rpmHeader) AddInt32(tag int, values ...int32) {
+	var data bytes.Buffer
+	binary.Write(&data, binary.BigEndian, values)
+	h.entries[tag] = rpmEntry{dataType: rpmInt32, count: len(values), data: data.Bytes()}
+}
+
+// AddInt16 adds an entry holding the 16 bit values
+func (h *rpmHeader) AddInt16(tag int, values ...int16) {
+	var data bytes.Buffer
+	binary.Write(&data, binary.BigEndian, values)
+	h.entries[tag] = rpmEntry{dataType: rpmInt16, count: len(values), data: data.Bytes()}
+}
+
+// AddBinary adds an entry holding the raw value
+func (h *rpmHeader) AddBinary(tag int, value []byte) {
+	h.entries[tag] = rpmEntry{dataType: rpmBinary, count: len(value), data: value}
+}
+
+// Bytes encodes the header. The entries are wrapped in a region with the
+// given tag, which rpm uses to find the original header when it is
+// verifying the package.
+func (h *rpmHeader) Bytes(regionTag int) []byte {
+	tags := []int{}
+	for tag := range h.entries {
+		tags = append(tags, tag)
+	}
+	sort.Ints(tags)
+
+	var index, store bytes.Buffer
+	for _, tag := range tags {
+		entry := h.entries[tag]
+		// numbers are aligned to their size within the store
+		align := map[int]int{rpmInt16: 2, rpmInt32: 4}[entry.dataType]
+		if align > 0 && store.Len()%align != 0 {
+			store.Write(make([]byte, align-store.Len()%align))
+		}
+		binary.Write(&index, binary.BigEndian, []int32{int32(tag),
+			int32(entry.dataType), int32(store.Len()), int32(entry.count)})
+		store.Write(entry.data)
+	}
+
+	// the region trailer points back at the start of the index
+	count := len(tags) + 1
+	regionOffset := store.Len()
+	binary.Write(&store, binary.BigEndian, []int32{int32(regionTag), rpmBinary,
+		int32(-16 * count), 16})
+
+	var out bytes.Buffer
+	out.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&out, binary.BigEndian, []int32{int32(count), int32(store.Len()),
+		int32(regionTag), rpmBinary, int32(regionOffset), 16})
+	out.Write(index.Bytes())
+	out.Write(store.Bytes())
+	return out.Bytes()
+}
+
+// writeCpio writes the entries to a cpio archive in the "new ascii" format
+// used by rpm, and returns the number of bytes written
+func writeCpio(w io.Writer, entries []archiveEntry) (int64, error) {
+	cw := &countWriter{w: w}
+	for i, entry := range entries {
+		data, err := readArchiveEntry(entry)
+		if err != nil {
+			return cw.n, err
+		}
+		err = writeCpioEntry(cw, "./"+entry.name, i+1, cpioMode(entry.info),
+			entry.info.ModTime().Unix(), data)
+		if err != nil {
+			return cw.n, errors.Wrapf(err, "writing %s", entry.name)
+		}
+	}
+	err := writeCpioEntry(cw, "TRAILER!!!", 0, 0, 0, nil)
+	return cw.n, err
+}
+
+func writeCpioEntry(w io.Writer, name string, inode int, mode uint32,
+	modTime int64, data []byte) error {
+	nlink := 1
+	_, err := fmt.Fprintf(w, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%s\x00",
+		inode, mode, 0, 0, nlink, modTime, len(data), 0, 0, 0, 0, len(name)+1, 0, name)
+	if err != nil {
+		return err
+	}
+	// the header and data are each padded to a multiple of 4 bytes
+	padding := make([]byte, 3)
+	if _, err := w.Write(padding[:(4-(110+len(name)+1)%4)%4]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err = w.Write(padding[:(4-len(data)%4)%4])
+	return err
+}
+
+// cpioMode converts the file mode to the unix mode stored by cpio and rpm
+func cpioMode(info os.FileInfo) uint32 {
+	mode := uint32(info.Mode().Perm())
+	if info.IsDir() {
+		return mode | 0040000
+	}
+	return mode | 0100000
+}
+
+// countWriter counts the bytes written through it
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRpmDependency(t *testing.T) {
+	name, flags, version, err := parseRpmDependency("glibc >= 2.17")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, "glibc", name, "name does not match")
+	assert.Equal(t, int32(rpmSenseGreater|rpmSenseEqual), flags, "flags do not match")
+	assert.Equal(t, "2.17", version, "version does not match")
+
+	name, flags, version, err = parseRpmDependency("bash")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, "bash", name, "name does not match")
+	assert.Equal(t, int32(0), flags, "flags do not match")
+	assert.Equal(t, "", version, "version does not match")
+
+	_, _, _, err = parseRpmDependency("glibc ~ 2.17")
+	assert.Error(t, err, "expected an error")
+}
+
+func TestRpmHeader_Bytes(t *testing.T) {
+	h := newRpmHeader()
+	h.AddString(rpmTagName, "gop")
+	h.AddInt16(rpmTagFileModes, 0644)
+	data := h.Bytes(rpmTagHeaderImmutable)
+
+	assert.Equal(t, []byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0}, data[:8], "magic does not match")
+	var counts [2]int32
+	binary.Read(bytes.NewReader(data[8:16]), binary.BigEndian, &counts)
+	// name, mode and the region trailer
+	assert.Equal(t, [2]int32{3, 4 + 2 + 16}, counts, "header size does not match")
+
+	var region, trailer [4]int32
+	binary.Read(bytes.NewReader(data[16:32]), binary.BigEndian, &region)
+	binary.Read(bytes.NewReader(data[len(data)-16:]), binary.BigEndian, &trailer)
+	assert.Equal(t, [4]int32{rpmTagHeaderImmutable, rpmBinary, 6, 16}, region, "region does not match")
+	assert.Equal(t, [4]int32{rpmTagHeaderImmutable, rpmBinary, -48, 16}, trailer, "trailer does not match")
+}
+
+func TestWriteRpm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	exePath := filepath.Join(dir, "exe")
+	readmePath := filepath.Join(dir, "README.md")
+	assert.NoError(t, ioutil.WriteFile(exePath, []byte("binary"), 0700))
+	assert.NoError(t, ioutil.WriteFile(readmePath, []byte("readme"), 0600))
+
+	pkg := Package{OS: "linux", Arch: "amd64", Dir: "exe", Archive: "rpm", ExePath: exePath,
+		FileList: []string{exePath, readmePath}}
+	opts := ArchiveOptions{Reproducible: true, ModTime: ReproducibleModTime,
+		PackageInfo: map[string]LinuxPackageInfo{"rpm": {Version: "1.0.0"}}}
+	var out bytes.Buffer
+	assert.NoError(t, writeRpm(&out, pkg, opts), "unexpected error")
+	data := out.Bytes()
+
+	// lead
+	assert.Equal(t, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0}, data[:6], "lead magic does not match")
+	assert.Equal(t, "exe-1.0.0-1", strings.TrimRight(string(data[10:76]), "\x00"), "lead name does not match")
+	data = data[96:]
+
+	// the signature header is padded to 8 bytes, the main header is not
+	sig, sigSize := parseTestRpmHeader(t, data)
+	data = data[sigSize+(8-sigSize%8)%8:]
+	header, headerSize := parseTestRpmHeader(t, data)
+	headerBytes, payload := data[:headerSize], data[headerSize:]
+
+	assert.Equal(t, int32(len(headerBytes)+len(payload)), testRpmInt32s(sig[rpmSigTagSize])[0],
+		"signature size does not match")
+	sha := sha256.Sum256(headerBytes)
+	assert.Equal(t, hex.EncodeToString(sha[:])+"\x00", string(sig[rpmSigTagSHA256]),
+		"signature sha256 does not match")
+	md5sum := md5.Sum(data)
+	assert.Equal(t, md5sum[:], sig[rpmSigTagMD5], "signature md5 does not match")
+
+	gz, err := gzip.NewReader(bytes.NewReader(payload))
+	assert.NoError(t, err, "unexpected error")
+	cpio, err := ioutil.ReadAll(gz)
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, int32(len(cpio)), testRpmInt32s(sig[rpmSigTagPayloadSize])[0],
+		"signature payload size does not match")
+
+	names, modes, contents := parseTestCpio(t, cpio)
+	assert.Equal(t, []string{"./usr/bin/exe", "./usr/share/doc/exe/README.md"}, names,
+		"payload names do not match")
+	assert.Equal(t, []uint32{0100755, 0100644}, modes, "payload modes do not match")
+	assert.Equal(t, []string{"binary", "readme"}, contents, "payload contents do not match")
+
+	// the modes do not fit in a positive int16, and must survive the wraparound
+	var headerModes []uint16
+	for _, mode := range testRpmInt16s(header[rpmTagFileModes]) {
+		headerModes = append(headerModes, uint16(mode))
+	}
+	assert.Equal(t, []uint16{0100755, 0100644}, headerModes, "header modes do not match")
+	assert.Equal(t, "exe\x00README.md\x00", string(header[rpmTagBaseNames]), "base names do not match")
+	assert.Equal(t, "/usr/bin/\x00/usr/share/doc/exe/\x00", string(header[rpmTagDirNames]),
+		"dir names do not match")
+	assert.Equal(t, []int32{6, 6}, testRpmInt32s(header[rpmTagFileSizes]), "file sizes do not match")
+}
+
+// parseTestRpmHeader reads the entries of an rpm header, returning the data
+// of each tag and the size of the header
+func parseTestRpmHeader(t *testing.T, data []byte) (map[int][]byte, int) {
+	assert.Equal(t, []byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0}, data[:8], "header magic does not match")
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	storeSize := int(binary.BigEndian.Uint32(data[12:]))
+	store := data[16+16*count : 16+16*count+storeSize]
+
+	entries := map[int][]byte{}
+	for i := 0; i < count; i++ {
+		entry := data[16+16*i:]
+		tag := int(binary.BigEndian.Uint32(entry))
+		dataType := int(binary.BigEndian.Uint32(entry[4:]))
+		offset := int(binary.BigEndian.Uint32(entry[8:]))
+		items := int(binary.BigEndian.Uint32(entry[12:]))
+
+		size := items
+		switch dataType {
+		case rpmInt16:
+			size = 2 * items
+		case rpmInt32:
+			size = 4 * items
+		case rpmString, rpmStringArray, rpmI18NString:
+			size = 0
+			for n := 0; n < items; n++ {
+				size += bytes.IndexByte(store[offset+size:], 0) + 1
+			}
+		}
+		entries[tag] = store[offset : offset+size]
+	}
+	return entries, 16 + 16*count + storeSize
+}
+
+func testRpmInt32s(data []byte) []int32 {
+	values := make([]int32, len(data)/4)
+	binary.Read(bytes.NewReader(data), binary.BigEndian, values)
+	return values
+}
+
+func testRpmInt16s(data []byte) []int16 {
+	values := make([]int16, len(data)/2)
+	binary.Read(bytes.NewReader(data), binary.BigEndian, values)
+	return values
+}
+
+// parseTestCpio reads the entries of a "new ascii" cpio archive
+func parseTestCpio(t *testing.T, data []byte) ([]string, []uint32, []string) {
+	names, modes, contents := []string{}, []uint32{}, []string{}
+	field := func(i int) int {
+		value, err := strconv.ParseUint(string(data[6+8*i:14+8*i]), 16, 32)
+		assert.NoError(t, err, "unexpected error")
+		return int(value)
+	}
+	for {
+		assert.Equal(t, "070701", string(data[:6]), "cpio magic does not match")
+		mode, size, nameSize := field(1), field(6), field(11)
+		name := string(data[110 : 110+nameSize-1])
+		if name == "TRAILER!!!" {
+			return names, modes, contents
+		}
+		offset := 110 + nameSize
+		offset += (4 - offset%4) % 4
+		names = append(names, name)
+		modes = append(modes, uint32(mode))
+		contents = append(contents, string(data[offset:offset+size]))
+		offset += size + (4-size%4)%4
+		data = data[offset:]
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ScoopInfo is the metadata of a Scoop manifest, set in the "scoop-manifest"
+// section of the config file
+type ScoopInfo struct {
+	Description string `mapstructure:"description"`
+	Homepage    string `mapstructure:"homepage"`
+	License     string `mapstructure:"license"`
+	Version     string `mapstructure:"version"`
+	URL         string `mapstructure:"url"`
+}
+
+// ScoopArchs maps the Go architectures to the Scoop architectures
+var ScoopArchs = map[string]string{
+	"386":   "32bit",
+	"amd64": "64bit",
+}
+
+// ScoopManifest is a Scoop app manifest installing the executable from the
+// windows zip archives
+type ScoopManifest struct {
+	Version      string                  `json:"version"`
+	Description  string                  `json:"description,omitempty"`
+	Homepage     string                  `json:"homepage,omitempty"`
+	License      string                  `json:"license,omitempty"`
+	Architecture map[string]ScoopArchive `json:"architecture"`
+	Bin          string                  `json:"bin"`
+}
+
+// ScoopArchive is an archive referenced by a Scoop manifest
+type ScoopArchive struct {
+	URL        string `json:"url"`
+	Hash       string `json:"hash"`
+	ExtractDir string `json:"extract_dir,omitempty"`
+}
+
+// NewScoopManifest creates a Scoop manifest referencing the windows zip
+// archives of the packages. The sha256 of each archive is read from its
+// archive path.
+func NewScoopManifest(packages []Package, info ScoopInfo) (ScoopManifest, error) {
+	manifest := ScoopManifest{
+		Version:      info.Version,
+		Description:  info.Description,
+		Homepage:     info.Homepage,
+		License:      info.License,
+		Architecture: map[string]ScoopArchive{},
+	}
+	if info.Version == "" {
+		return manifest, errors.New("scoop-manifest.version or --version-string must be set")
+	}
+	if info.URL == "" {
+		return manifest, errors.New("scoop-manifest.url must be set in the config file")
+	}
+	urlTpl, err := newTemplate("url", info.URL)
+	if err != nil {
+		return manifest, errors.Wrap(err, "scoop url template error")
+	}
+
+	for _, pkg := range packages {
+		arch, ok := ScoopArchs[pkg.Arch]
+		if !ok || pkg.OS != "windows" || CanonicalArchive(pkg.Archive) != "zip" {
+			continue
+		}
+		if _, ok := manifest.Architecture[arch]; ok {
+			continue
+		}
+
+		url, sum, err := getReleaseURL(urlTpl, pkg, info.Version)
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Architecture[arch] = ScoopArchive{URL: url, Hash: sum,
+			ExtractDir: strings.Trim(pkg.ArchiveRoot, "/")}
+		if manifest.Bin == "" {
+			manifest.Bin = pkg.BinaryName
+			if manifest.Bin == "" {
+				manifest.Bin = filepath.Base(pkg.ExePath)
+			}
+		}
+	}
+
+	if len(manifest.Architecture) == 0 {
+		return manifest, errors.New("no windows zip archives to add to the scoop manifest")
+	}
+	return manifest, nil
+}
+
+// WriteScoopManifest writes the manifest to path as JSON
+func WriteScoopManifest(path string, manifest ScoopManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding scoop manifest")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "making scoop manifest folder")
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return errors.Wrapf(err, "writing %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewScoopManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	assert.NoError(t, err, "unexpected error")
+	defer os.RemoveAll(dir)
+
+	packages := []Package{}
+	for _, platform := range [][3]string{
+		{"windows", "amd64", "tar.gz"},
+		{"windows", "amd64", "zip"},
+		{"windows", "arm", "zip"},
+		{"linux", "386", "zip"},
+	} {
+		archivePath := filepath.Join(dir, platform[0]+"_"+platform[1]+"."+platform[2])
+		assert.NoError(t, ioutil.WriteFile(archivePath, []byte("archive"), 0644))
+		packages = append(packages, Package{OS: platform[0], Arch: platform[1],
+			Archive: platform[2], ArchivePath: archivePath, BinaryName: "gop.exe"})
+	}
+
+	manifest, err := NewScoopManifest(packages, ScoopInfo{Version: "1.0.0",
+		URL: "https://example.com/v{{.Version}}/{{.ArchiveName}}"})
+	assert.NoError(t, err, "unexpected error")
+	expected := map[string]ScoopArchive{"64bit": {
+		URL:  "https://example.com/v1.0.0/windows_amd64.zip",
+		Hash: "0eb3e36bfb24dcd9bb1d1bece1531216b59539a8fde17ee80224af0653c92aa3",
+	}}
+	assert.Equal(t, expected, manifest.Architecture, "architectures do not match")
+	assert.Equal(t, "gop.exe", manifest.Bin, "bin does not match")
+}
+
+func TestNewScoopManifest_NoVersion(t *testing.T) {
+	_, err := NewScoopManifest([]Package{}, ScoopInfo{URL: "{{.ArchiveName}}"})
+	assert.Error(t, err, "expected an error")
+}
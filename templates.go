@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"text/template"
+)
+
+// TemplateFuncs are the functions available to the user templates. The value
+// being worked on is the last argument, so they can be used in pipelines such
+// as {{.Arch | replace "amd64" "x86_64"}}.
+var TemplateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"title":      strings.Title,
+	"replace":    templateReplace,
+	"trimPrefix": templateTrimPrefix,
+	"default":    templateDefault,
+	"env":        os.Getenv,
+	"exe":        templateExe,
+}
+
+func templateReplace(old string, new string, value string) string {
+	return strings.Replace(value, old, new, -1)
+}
+
+func templateTrimPrefix(prefix string, value string) string {
+	return strings.TrimPrefix(value, prefix)
+}
+
+func templateDefault(defaultValue string, value string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// templateExe is the executable extension of the operating system
+func templateExe(goos string) string {
+	if strings.ToLower(goos) == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+// newTemplate parses a user template after expanding any $VAR or ${VAR}
+// environment variables found outside of the template actions. Missing map
+// keys, such as an unset {{.Env.NAME}}, are left empty rather than printed as
+// "<no value>".
+func newTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(TemplateFuncs).Option("missingkey=zero").
+		Parse(expandTemplateEnv(text))
+}
+
+// expandTemplateEnv expands the environment variables in the text, leaving
+// everything between "{{" and "}}" untouched so that template variables such
+// as $x keep working
+func expandTemplateEnv(text string) string {
+	var expanded strings.Builder
+	for {
+		start := strings.Index(text, "{{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(text[start:], "}}")
+		if end < 0 {
+			break
+		}
+		end += start + 2
+		expanded.WriteString(os.ExpandEnv(text[:start]))
+		expanded.WriteString(text[start:end])
+		text = text[end:]
+	}
+	expanded.WriteString(os.ExpandEnv(text))
+	return expanded.String()
+}
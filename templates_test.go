@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandTemplateEnv(t *testing.T) {
+	os.Setenv("GOP_TEST_VERSION", "1.2.3")
+	defer os.Unsetenv("GOP_TEST_VERSION")
+
+	assert.Equal(t, "{{.Dir}}_1.2.3_{{.OS}}.{{.Archive}}",
+		expandTemplateEnv("{{.Dir}}_${GOP_TEST_VERSION}_{{.OS}}.{{.Archive}}"))
+	assert.Equal(t, "1.2.3/{{$os := .OS}}{{$os}}",
+		expandTemplateEnv("$GOP_TEST_VERSION/{{$os := .OS}}{{$os}}"))
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	pkg := Package{Dir: "exe", OS: "windows", Arch: "amd64",
+		BuildInfo: BuildInfo{Version: "v1.2.3"}}
+	tests := map[string]string{
+		`{{.Arch | replace "amd64" "x86_64"}}`:     "x86_64",
+		`{{title .OS}}-{{upper .Dir}}`:             "Windows-EXE",
+		`{{lower "ABC"}}`:                          "abc",
+		`{{.Version | trimPrefix "v"}}`:            "1.2.3",
+		`{{.Commit | default "none"}}`:             "none",
+		`{{.Dir}}{{exe .OS}}`:                      "exe.exe",
+		`{{env "GOP_TEST_MISSING" | default "-"}}`: "-",
+	}
+	for text, expected := range tests {
+		tpl, err := newTemplate("test", text)
+		assert.NoError(t, err, "unexpected error")
+		var result strings.Builder
+		assert.NoError(t, tpl.Execute(&result, &pkg), "unexpected error")
+		assert.Equal(t, expected, result.String(), "%s does not match", text)
+	}
+}